@@ -4,29 +4,160 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/coldog/bld/pkg/builder"
 	"github.com/coldog/bld/pkg/executor"
 	"github.com/coldog/bld/pkg/log"
+	"github.com/coldog/bld/pkg/progress"
+	"github.com/coldog/bld/pkg/registry"
 	"github.com/coldog/bld/pkg/runner"
 	"github.com/coldog/bld/pkg/store"
+	"github.com/ghodss/yaml"
 	uuid "github.com/satori/go.uuid"
 )
 
+// version is set at build time.
+var version = "dev"
+
 func exitErr(msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg+"\n", args...)
 	os.Exit(1)
 }
 
+// handleSignals cancels the build on the first SIGINT or SIGTERM, a second
+// signal exits immediately.
+func handleSignals(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-sigs
+	fmt.Fprintf(os.Stderr, "Received %v, stopping build (repeat to force)\n", sig)
+	cancel()
+
+	<-sigs
+	exitErr("Forced exit")
+}
+
+// varsFlag collects repeated `-var key=value` flags.
+type varsFlag map[string]string
+
+func (v varsFlag) String() string { return fmt.Sprint(map[string]string(v)) }
+
+func (v varsFlag) Set(s string) error {
+	spl := strings.SplitN(s, "=", 2)
+	if len(spl) != 2 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	v[spl[0]] = spl[1]
+	return nil
+}
+
+// loadBuild reads the build spec with the variables from varFile and vars,
+// vars take precedence. The build is given a new ID.
+func loadBuild(buildSpec, varFile string, vars map[string]string) builder.Build {
+	overrides := map[string]string{}
+	if varFile != "" {
+		fileVars, err := builder.ReadVars(varFile)
+		if err != nil {
+			exitErr("Failed to read vars: %v", err)
+		}
+		overrides = fileVars
+	}
+	for k, v := range vars {
+		overrides[k] = v
+	}
+	b, err := builder.Load(buildSpec, builder.LoadOptions{
+		Vars:    overrides,
+		BuildID: uuid.NewV4().String(),
+	})
+	if err != nil {
+		exitErr("Failed to read (%s): %v", buildSpec, err)
+	}
+	return b
+}
+
+// renderCmd prints the build after templating and matrix expansion:
+// `bld render`.
+func renderCmd(b builder.Build) {
+	data, err := yaml.Marshal(b)
+	if err != nil {
+		exitErr("Failed to render build: %v", err)
+	}
+	os.Stdout.Write(data)
+}
+
+// logsCmd prints the saved output of a step: `bld logs <step> [-build ID]`.
+func logsCmd(s store.Store, args []string) {
+	if len(args) < 1 {
+		exitErr("Usage: bld logs <step> [-build ID]")
+	}
+	var buildID string
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	fs.StringVar(&buildID, "build", "", "build ID, defaults to the latest build")
+	fs.Parse(args[1:])
+
+	r, err := runner.Logs(s, buildID, args[0])
+	if err != nil {
+		exitErr("Failed to read logs: %v", err)
+	}
+	defer r.Close()
+	io.Copy(os.Stdout, r)
+}
+
+// imageCmd handles image commands:
+// `bld image export <step> -o out.tar [-build ID]`.
+func imageCmd(s store.Store, args []string) {
+	if len(args) < 2 || args[0] != "export" {
+		exitErr("Usage: bld image export <step> -o <file> [-build ID]")
+	}
+	var buildID, file string
+	fs := flag.NewFlagSet("image export", flag.ExitOnError)
+	fs.StringVar(&buildID, "build", "", "build ID, defaults to the latest build")
+	fs.StringVar(&file, "o", "", "output OCI image layout tar archive")
+	fs.Parse(args[2:])
+	if file == "" {
+		exitErr("Usage: bld image export <step> -o <file> [-build ID]")
+	}
+
+	step := args[1]
+	digest, err := runner.ImageDigest(s, buildID, step)
+	if err != nil {
+		exitErr("Failed to find image: %v", err)
+	}
+	is, err := store.NewImageStore(s)
+	if err != nil {
+		exitErr("Invalid repo store %s", err)
+	}
+	if err := runner.ExportImage(context.Background(), is, step, digest, file); err != nil {
+		exitErr("Failed to export image: %v", err)
+	}
+}
+
 func main() {
 	var (
-		buildDir    string
-		buildSpec   string
-		rootDir     string
-		backend     string
-		concurrency int
-		level       uint
+		buildDir     string
+		buildSpec    string
+		rootDir      string
+		backend      string
+		concurrency  int
+		level        uint
+		keepGoing    bool
+		stopTimeout  time.Duration
+		debug        bool
+		replayLogs   bool
+		logFormat    string
+		showProgress bool
+		reportFile   string
+		reportsDir   string
+		junitFile    string
+		varFile      string
+		vars         = varsFlag{}
 	)
 	wd, _ := os.Getwd()
 
@@ -35,22 +166,25 @@ func main() {
 	flag.StringVar(&rootDir, "root-dir", wd, "root directory for the build")
 	flag.StringVar(&backend, "backend", "local", "storage backend options: [local]")
 	flag.UintVar(&level, "v", 0, "log verbosity")
+	flag.StringVar(&reportFile, "report", "", "write a JSON build report to this file")
+	flag.StringVar(&reportsDir, "reports-dir", "", "collect step reports into this directory")
+	flag.StringVar(&junitFile, "junit", "", "write a JUnit report with a test case per step to this file")
+	flag.BoolVar(&showProgress, "progress", true, "show live progress when attached to a terminal")
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "log format options: [text, json]")
+	flag.Var(vars, "var", "set a build variable as key=value, can be repeated")
+	flag.StringVar(&varFile, "var-file", "", "YAML file of build variables")
 	flag.IntVar(&concurrency, "concurrency", 5, "maximum concurrency")
+	flag.DurationVar(&stopTimeout, "stop-timeout", 10*time.Second, "grace period for containers to stop when cancelled")
+	flag.BoolVar(&debug, "debug-on-failure", false, "open a shell in the container of a failed step")
+	flag.BoolVar(&replayLogs, "replay-logs", false, "print the saved output of cached steps")
+	flag.BoolVar(&keepGoing, "keep-going", false, "continue running steps not downstream of a failure")
 	flag.Parse()
 
 	log.Level(uint32(level))
-
-	var build builder.Build
-	{
-		b, err := builder.Read(buildSpec)
-		if err != nil {
-			exitErr("Failed to read (%s): %v", buildSpec, err)
-		}
-		build = b
+	if err := log.Format(logFormat); err != nil {
+		exitErr("Invalid log format: %v", err)
 	}
 
-	build.ID = uuid.NewV4().String()
-
 	var s store.Store
 	switch backend {
 	case "local":
@@ -59,31 +193,151 @@ func main() {
 		exitErr("Invalid store %s", backend)
 	}
 
+	// Commands that do not need the docker daemon.
+	switch flag.Arg(0) {
+	case "logs":
+		logsCmd(s, flag.Args()[1:])
+		return
+	case "image":
+		imageCmd(s, flag.Args()[1:])
+		return
+	case "render":
+		renderCmd(loadBuild(buildSpec, varFile, vars))
+		return
+	}
+
+	e := &executor.Executor{
+		StopTimeout:    stopTimeout,
+		Version:        version,
+		DebugOnFailure: debug,
+	}
+	if err := e.Open(); err != nil {
+		exitErr("Failed to initialize executor: %v", err)
+	}
+
+	// Orphaned containers are always reaped on startup.
+	removed, err := e.Cleanup(context.Background(), buildDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to clean up containers: %v\n", err)
+	}
+
+	var noCache, pushSteps []string
+	switch flag.Arg(0) {
+	case "":
+	case "push":
+		if flag.NArg() < 2 {
+			exitErr("Usage: bld push <step>...")
+		}
+		pushSteps = flag.Args()[1:]
+	case "cleanup":
+		fmt.Printf("Removed %d containers\n", len(removed))
+		return
+	case "shell":
+		if flag.NArg() != 2 {
+			exitErr("Usage: bld shell <step>")
+		}
+		e.ShellStep = flag.Arg(1)
+	default:
+		exitErr("Unknown command: %s", flag.Arg(0))
+	}
+
+	build := loadBuild(buildSpec, varFile, vars)
+
+	if e.ShellStep != "" {
+		step, ok := build.Step(e.ShellStep)
+		if !ok {
+			if renamed, ok := build.Renamed(e.ShellStep); ok {
+				exitErr("Step not found: %s, did you mean %s", e.ShellStep, renamed)
+			}
+			exitErr("Step not found: %s", e.ShellStep)
+		}
+		e.ShellStep = step.Name
+		noCache = append(noCache, step.Name)
+	}
+
+	if build.UsesSSH() {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			exitErr("Build uses ssh but SSH_AUTH_SOCK is not set")
+		}
+		if _, err := os.Stat(sock); err != nil {
+			exitErr("Invalid SSH_AUTH_SOCK: %v", err)
+		}
+		e.SSHAuthSock = sock
+	}
+
+	stopHeartbeat, err := executor.Heartbeat(buildDir, build.ID)
+	if err != nil {
+		exitErr("Failed to write build lock: %v", err)
+	}
+	defer stopHeartbeat()
+	// exitErr skips deferred calls, the lock is removed before exiting so it
+	// is not left to go stale.
+	fail := func(msg string, args ...interface{}) {
+		stopHeartbeat()
+		exitErr(msg, args...)
+	}
+
 	var imageStore store.ImageStore
 	{
 		is, err := store.NewImageStore(s)
 		if err != nil {
-			exitErr("Invalid repo store %s", err)
+			fail("Invalid repo store %s", err)
 		}
 		imageStore = is
 	}
 
-	e := &executor.Executor{}
-	if err := e.Open(); err != nil {
-		exitErr("Failed to initialize executor: %v", err)
+	pusher, err := registry.NewDockerPusher(registry.DefaultConfigFile())
+	if err != nil {
+		fail("Failed to initialize registry: %v", err)
 	}
 
 	r := &runner.Runner{
-		Store:      s,
-		ImageStore: imageStore,
-		BuildDir:   buildDir,
-		RootDir:    rootDir,
-		Build:      build,
-		Perform:    e.Execute,
-		Workers:    concurrency,
+		Store:        s,
+		ImageStore:   imageStore,
+		BuildDir:     buildDir,
+		RootDir:      rootDir,
+		Build:        build,
+		Perform:      e.Execute,
+		ResolveImage: e.ResolveImage,
+		Workers:      concurrency,
+		KeepGoing:    keepGoing,
+		NoCache:      noCache,
+		ReplayLogs:   replayLogs,
+		ReportsDir:   reportsDir,
+		Pusher:       pusher,
+		PushSteps:    pushSteps,
 	}
 
-	if err := r.Run(context.Background()); err != nil {
-		exitErr("Run failed: %v", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
+	// The progress renderer consumes the JSON log format. It is disabled when
+	// an interactive shell may take over the terminal.
+	interactive := e.DebugOnFailure || e.ShellStep != ""
+	var renderer *progress.Renderer
+	if showProgress && !interactive && logFormat == log.FormatText && progress.IsTerminal(os.Stdout) {
+		renderer = progress.New(os.Stdout)
+		log.Format(log.FormatJSON)
+		log.DefaultOutput(renderer)
+	}
+
+	err = r.Run(ctx)
+	if renderer != nil {
+		renderer.Close()
+	}
+	if reportFile != "" {
+		if rerr := r.WriteReport(reportFile); rerr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", rerr)
+		}
+	}
+	if junitFile != "" {
+		if jerr := r.WriteJUnit(junitFile); jerr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write JUnit report: %v\n", jerr)
+		}
+	}
+	if err != nil {
+		fail("Run failed: %v", err)
 	}
 }
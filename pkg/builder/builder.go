@@ -5,8 +5,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
 
-	"github.com/coldog/bld/pkg/template"
 	"github.com/xeipuuv/gojsonschema"
 )
 
@@ -19,15 +20,30 @@ type Build struct {
 	// Name represents a human name given to the build target.
 	Name string `json:"name"`
 
+	// Requires are paths or glob patterns of other build files, relative to
+	// this file, whose steps are run as part of the build. Their names are
+	// prefixed with the required build's name, for example "sub.install", and
+	// are referenced as "sub:install".
 	Requires []string `json:"requires"`
 
 	Volumes []Volume `json:"volumes"`
 	Sources []Source `json:"sources"`
 	Steps   []Step   `json:"steps"`
+	Outputs []Output `json:"outputs"`
+	Secrets []Secret `json:"secrets"`
+
+	// Vars are template variables with their default values, they are
+	// referenced as {{ .Vars.name }} and can be overridden with Load.
+	Vars map[string]string `json:"vars"`
+
+	// Inputs declare the values from outside the build file referenced by
+	// the fields of the build that are not steps, steps declare their own.
+	Inputs *Inputs `json:"inputs,omitempty"`
 }
 
 // Source will fetch a source if it exists.
 func (b Build) Source(name string) (Source, bool) {
+	name = b.resolve(name)
 	for _, src := range b.Sources {
 		if src.Name == name {
 			return src, true
@@ -36,15 +52,69 @@ func (b Build) Source(name string) (Source, bool) {
 	return Source{}, false
 }
 
+// Secret will fetch a secret if it exists.
+func (b Build) Secret(name string) (Secret, bool) {
+	for _, secret := range b.Secrets {
+		if secret.Name == name {
+			return secret, true
+		}
+	}
+	return Secret{}, false
+}
+
+// Renamed returns the name a step or source of a required build has now if
+// name uses the "_" separator that joined build names before, "sub_install"
+// is now "sub.install".
+func (b Build) Renamed(name string) (string, bool) {
+	for i, c := range name {
+		if c != '_' {
+			continue
+		}
+		renamed := name[:i] + namespaceSep + name[i+1:]
+		if _, ok := b.Step(renamed); ok {
+			return renamed, true
+		}
+		if _, ok := b.Source(renamed); ok {
+			return renamed, true
+		}
+		for _, s := range b.Steps {
+			if s.Exported(renamed) {
+				return renamed, true
+			}
+		}
+	}
+	return "", false
+}
+
+// UsesSSH returns true if any step forwards the SSH agent.
+func (b Build) UsesSSH() bool {
+	for _, s := range b.Steps {
+		if s.SSH {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the name of a reference to a required build, "sub:install",
+// as it is after the build is read.
+func (b Build) resolve(name string) string {
+	spl := strings.SplitN(name, ":", 2)
+	if len(spl) == 1 {
+		return name
+	}
+	if spl[0] == b.Name {
+		return spl[1]
+	}
+	return spl[0] + namespaceSep + spl[1]
+}
+
 // Step will fetch a step if it exists.
 func (b Build) Step(name string) (Step, bool) {
+	name = b.resolve(name)
 	for _, step := range b.Steps {
 		if step.Name == name {
-			s := &step
-			if err := template.Struct(s); err != nil {
-				panic(err)
-			}
-			return *s, true
+			return step, true
 		}
 	}
 	return Step{}, false
@@ -56,6 +126,10 @@ type Source struct {
 	Name   string   `json:"name"`
 	Target string   `json:"target"`
 	Files  []string `json:"files"`
+
+	// Dir is the directory of the build file that declared the source,
+	// relative to the root directory. Target is relative to it.
+	Dir string `json:"-"`
 }
 
 // Volume represents mountable cache volumes that can be mounted in the build
@@ -63,6 +137,79 @@ type Source struct {
 type Volume struct {
 	Name   string `json:"name"`
 	Target string `json:"target"`
+
+	// Dir is the directory a relative Target is resolved against, see
+	// Source.Dir.
+	Dir string `json:"-"`
+}
+
+// Output copies an exported source back to a directory on the host after the
+// build succeeds.
+type Output struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	// Clean removes the target before copying.
+	Clean bool `json:"clean"`
+	// Untracked fails the build if the target is tracked by git.
+	Untracked bool `json:"untracked"`
+
+	// Dir is the directory Target is relative to, see Source.Dir.
+	Dir string `json:"-"`
+}
+
+// Secret is a value read from an environment variable or a file on the host.
+// Secret values are never included in digests, logs or committed images.
+type Secret struct {
+	Name string `json:"name"`
+	Env  string `json:"env,omitempty"`
+	File string `json:"file,omitempty"`
+
+	// Dir is the directory File is relative to, see Source.Dir.
+	Dir string `json:"-"`
+}
+
+// SecretMount exposes a secret to a step as an environment variable or as a
+// file in the container.
+type SecretMount struct {
+	Name string `json:"name"`
+	Env  string `json:"env,omitempty"`
+	File string `json:"file,omitempty"`
+}
+
+// Service is a container started on a network shared with a step before the
+// step runs, it is reachable from the step by its name.
+type Service struct {
+	Name        string       `json:"name"`
+	Image       string       `json:"image"`
+	Env         []string     `json:"env,omitempty"`
+	Command     []string     `json:"command,omitempty"`
+	Healthcheck *Healthcheck `json:"healthcheck,omitempty"`
+}
+
+// Healthcheck is a command run inside a service container, the step starts once
+// it succeeds. Interval is a duration such as "1s".
+type Healthcheck struct {
+	Command  []string `json:"command"`
+	Interval string   `json:"interval,omitempty"`
+	Retries  int      `json:"retries,omitempty"`
+}
+
+// MatrixValues are the values of a matrix key, numbers and booleans are read
+// as strings.
+type MatrixValues []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *MatrixValues) UnmarshalJSON(data []byte) error {
+	var values []interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*m = MatrixValues{}
+	for _, v := range values {
+		*m = append(*m, fmt.Sprint(v))
+	}
+	return nil
 }
 
 // Mount references a source directory and a mount directory in the container.
@@ -71,6 +218,13 @@ type Mount struct {
 	Mount  string `json:"mount"`
 }
 
+// Report references files in an exported source that are collected after the
+// step runs, Path is a glob relative to the source.
+type Report struct {
+	Source string `json:"source"`
+	Path   string `json:"path"`
+}
+
 // Image is a committed image.
 type Image struct {
 	Tag        string   `json:"tag"`
@@ -79,6 +233,58 @@ type Image struct {
 	Env        []string `json:"env"`
 	Workdir    string   `json:"workdir"`
 	User       string   `json:"user"`
+
+	// Push will push the image once the build succeeds.
+	Push *Push `json:"push,omitempty"`
+
+	// OCI writes the image as an OCI image layout tar archive to this path
+	// once the build succeeds.
+	OCI string `json:"oci,omitempty"`
+
+	// Dir is the directory a relative OCI path is resolved against, see
+	// Source.Dir.
+	Dir string `json:"-"`
+}
+
+// Tags returns the tags the image is pushed to.
+func (i Image) Tags() []string {
+	if i.Push == nil {
+		return nil
+	}
+	if len(i.Push.Tags) > 0 {
+		return i.Push.Tags
+	}
+	if i.Push.Enabled && i.Tag != "" {
+		return []string{i.Tag}
+	}
+	return nil
+}
+
+// Push is configured as either `true`, pushing the image tag, or as a list of
+// tags to push.
+type Push struct {
+	Enabled bool
+	Tags    []string
+}
+
+// UnmarshalJSON accepts a boolean or a list of tags.
+func (p *Push) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.Enabled); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &p.Tags); err != nil {
+		return fmt.Errorf("push must be a boolean or a list of tags: %v", err)
+	}
+	p.Enabled = len(p.Tags) > 0
+	return nil
+}
+
+// MarshalJSON returns the same form that was unmarshalled.
+func (p Push) MarshalJSON() ([]byte, error) {
+	if len(p.Tags) > 0 {
+		return json.Marshal(p.Tags)
+	}
+	return json.Marshal(p.Enabled)
 }
 
 // Step represents instructions for a step.
@@ -98,12 +304,102 @@ type Step struct {
 	Volumes []Mount `json:"volumes"`
 	Exports []Mount `json:"exports"`
 
+	// Reports are JUnit files collected from exports.
+	Reports []Report `json:"reports,omitempty"`
+
+	// Secrets are injected when the step runs, they are not part of the digest.
+	Secrets []SecretMount `json:"secrets,omitempty"`
+
+	// SSH forwards the host SSH agent into the container, it is not part of
+	// the digest and can not be used by steps that commit an image.
+	SSH bool `json:"ssh,omitempty"`
+
+	// Services are started before the step and removed once it exits.
+	Services []Service `json:"services,omitempty"`
+
+	// Inputs declare the environment variables and git values referenced by
+	// the step's templates, references that are not declared fail the build.
+	Inputs *Inputs `json:"inputs,omitempty"`
+
+	// Matrix expands the step into one step per combination of values when the
+	// build is read, values are referenced as {{ .Matrix.key }}.
+	Matrix map[string]MatrixValues `json:"matrix,omitempty"`
+
 	// Build will commit a built container.
 	Build *Image `json:"build"`
+
+	// Dockerfile builds an image with the docker build API instead of running
+	// commands. The path is relative to the Context source.
+	Dockerfile string   `json:"dockerfile,omitempty"`
+	Context    string   `json:"context,omitempty"`
+	Args       []string `json:"args,omitempty"`
+}
+
+// Inputs are the values from outside the build file a step depends on.
+type Inputs struct {
+	Env []string `json:"env,omitempty"`
+	Git []string `json:"git,omitempty"`
+	// Build lists "id" to use the build ID and "now" to use the now function.
+	Build []string `json:"build,omitempty"`
+}
+
+// Volatile returns the declared inputs that change on every commit or build, a
+// step that depends on them is never restored from the cache of a previous
+// commit or build.
+func (s Step) Volatile() []string {
+	volatile := []string{}
+	if s.Inputs == nil {
+		return volatile
+	}
+	for _, key := range s.Inputs.Git {
+		switch strings.ToLower(key) {
+		case "sha", "shashort", "time":
+			volatile = append(volatile, "git."+key)
+		}
+	}
+	for _, key := range s.Inputs.Build {
+		volatile = append(volatile, "build."+key)
+	}
+	return volatile
+}
+
+// Commits returns true if the step produces an image.
+func (s Step) Commits() bool { return s.Build != nil || s.Dockerfile != "" }
+
+// Sources returns the names of the sources the step depends on.
+func (s Step) Sources() []string {
+	sources := []string{}
+	for _, imp := range s.Imports {
+		sources = append(sources, imp.Source)
+	}
+	if s.Context != "" {
+		sources = append(sources, s.Context)
+	}
+	return sources
+}
+
+// Exported returns true if the step exports the source.
+func (s Step) Exported(source string) bool {
+	for _, exp := range s.Exports {
+		if exp.Source == source {
+			return true
+		}
+	}
+	return false
 }
 
 // Digest returns a digest for the build.
 func (s Step) Digest() string {
+	s.Secrets = nil
+	s.SSH = false
+	if s.Build != nil {
+		// Pushing and exporting happen after the build, they do not change
+		// the image.
+		b := *s.Build
+		b.Push = nil
+		b.OCI = ""
+		s.Build = &b
+	}
 	data, _ := json.Marshal(s)
 	h := sha256.New()
 	h.Write(data)
@@ -120,6 +416,21 @@ type StepExec struct {
 	BuildDir   string
 	RootDir    string
 	SourceDirs map[string]string
+
+	// Output receives the combined stdout and stderr of the step if set.
+	Output io.Writer `json:"-"`
+
+	// SecretValues holds the values of the secrets used by the step by name.
+	SecretValues map[string]string `json:"-"`
+}
+
+// ExitError is returned by an executor when a step exits with a non-zero code.
+type ExitError struct {
+	Code int
+}
+
+func (e ExitError) Error() string {
+	return fmt.Sprintf("container: exit code %d", e.Code)
 }
 
 // Validate will validate JSON against the provided schema.
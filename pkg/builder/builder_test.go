@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStepDigestStable checks that fields added to steps do not change the
+// digest of steps that do not use them, which would invalidate every cache.
+func TestStepDigestStable(t *testing.T) {
+	step := Step{
+		Name:     "test",
+		Image:    "alpine",
+		Commands: []string{"echo hello"},
+		Build:    &Image{Tag: "bld/test"},
+	}
+	require.Equal(t,
+		"ef859a470545889f1f9302cdb80f9512b812c062294bf6c73632543b49f06c7c",
+		step.Digest())
+}
+
+func TestStepDigestPush(t *testing.T) {
+	step := Step{Name: "test", Build: &Image{Tag: "bld/test"}}
+	digest := step.Digest()
+
+	step.Build.Push = &Push{Tags: []string{"registry/test:1"}}
+	require.Equal(t, digest, step.Digest())
+	require.NotNil(t, step.Build.Push)
+}
+
+func TestStepDigestOCI(t *testing.T) {
+	step := Step{Name: "test", Build: &Image{Tag: "bld/test"}}
+	digest := step.Digest()
+
+	step.Build.OCI = "dist/test.tar"
+	require.Equal(t, digest, step.Digest())
+	require.Equal(t, "dist/test.tar", step.Build.OCI)
+}
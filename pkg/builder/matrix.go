@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// matrixCombinations returns every combination of the matrix values, keys are
+// iterated in sorted order and values in their declared order.
+func matrixCombinations(matrix map[string]MatrixValues) []map[string]string {
+	keys := []string{}
+	for key := range matrix {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		next := []map[string]string{}
+		for _, c := range combinations {
+			for _, val := range matrix[key] {
+				m := map[string]string{key: val}
+				for k, v := range c {
+					m[k] = v
+				}
+				next = append(next, m)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// suffixRe matches the characters that are replaced in a matrix suffix, the
+// suffix is used in step and source names so "lts/carbon" becomes
+// "lts-carbon" and "1.10" becomes "1-10".
+var suffixRe = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// matrixSuffix returns the suffix for a combination, for example "node-8".
+func matrixSuffix(values map[string]string) string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		parts = append(parts, suffixRe.ReplaceAllString(key+"-"+values[key], "-"))
+	}
+	return strings.Join(parts, "_")
+}
+
+// matrixStep is a step expanded from a matrix and the values it is rendered
+// with.
+type matrixStep struct {
+	Step
+	values map[string]string
+}
+
+// expandMatrix returns one step per combination of the step matrix, a step
+// without a matrix is returned as it is. Expanded steps, their exports and
+// reports are suffixed with the combination values.
+func expandMatrix(step Step) ([]matrixStep, error) {
+	if len(step.Matrix) == 0 {
+		return []matrixStep{{Step: step}}, nil
+	}
+	steps := []matrixStep{}
+	suffixes := map[string]bool{}
+	for _, values := range matrixCombinations(step.Matrix) {
+		// Decode a copy so the slices of the original step are not shared
+		// between combinations.
+		data, err := json.Marshal(step)
+		if err != nil {
+			return nil, err
+		}
+		var s Step
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		s.Matrix = nil
+		suffix := matrixSuffix(values)
+		if suffixes[suffix] {
+			return nil, fmt.Errorf("step %s: matrix values expand to the same name %s", step.Name, suffix)
+		}
+		suffixes[suffix] = true
+		s.Name = step.Name + "_" + suffix
+		for i, exp := range s.Exports {
+			exp.Source = exp.Source + "_" + suffix
+			s.Exports[i] = exp
+		}
+		for i, report := range s.Reports {
+			report.Source = report.Source + "_" + suffix
+			s.Reports[i] = report
+		}
+		steps = append(steps, matrixStep{Step: s, values: values})
+	}
+	return steps, nil
+}
@@ -1,10 +1,13 @@
 package builder
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/coldog/bld/pkg/template"
 	"github.com/ghodss/yaml"
 )
 
@@ -33,60 +36,471 @@ func readBuild(filename string) (Build, error) {
 	return build, nil
 }
 
-func addNamespace(b *Build) {
-	const sep = "_"
+// buildFile is a build read from a file.
+type buildFile struct {
+	filename string
+	abs      string
+	build    *Build
+}
+
+// readAll reads the build file and, recursively, the builds it requires.
+// Required paths are relative to the requiring file and may be glob patterns.
+// Every file is read once, a file that requires itself is an error.
+func readAll(filename string, stack []string, files *[]*buildFile) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	for i, f := range stack {
+		if f == abs {
+			return fmt.Errorf("requires cycle: %s", strings.Join(append(stack[i:], abs), " -> "))
+		}
+	}
+	for _, f := range *files {
+		if f.abs == abs {
+			return nil
+		}
+	}
+
+	b, err := readBuild(filename)
+	if err != nil {
+		if len(stack) > 0 {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		return err
+	}
+	*files = append(*files, &buildFile{filename: filename, abs: abs, build: &b})
+
+	stack = append(stack, abs)
+	for _, pattern := range b.Requires {
+		paths, err := requirePaths(filepath.Dir(filename), pattern)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		for _, path := range paths {
+			if err := readAll(path, stack, files); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// requirePaths resolves a required path or glob pattern relative to dir.
+func requirePaths(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("requires %s matches no files", pattern)
+	}
+	return paths, nil
+}
+
+// namespaceSep joins the name of a required build and the names it declares,
+// names can not contain it so a prefixed name never collides with another.
+const namespaceSep = "."
+
+// namespace prefixes the names declared by a required build with the build
+// name and resolves references. Plain references are to names in the same
+// build, names in other builds are referenced as <build>:<name> and the build
+// must be one of builds. Names in the main build are not prefixed.
+func namespace(b *Build, main string, builds map[string]string) error {
+	var err error
+	name := func(n string) string {
+		if b.Name == main {
+			return n
+		}
+		return b.Name + namespaceSep + n
+	}
+	ref := func(r string) string {
+		if !strings.Contains(r, ":") {
+			return name(r)
+		}
+		if _, ok := builds[strings.SplitN(r, ":", 2)[0]]; !ok && err == nil {
+			err = fmt.Errorf("reference %s: build is not required", r)
+		}
+		return Build{Name: main}.resolve(r)
+	}
 
 	for idx, s := range b.Sources {
-		s.Name = b.Name + sep + s.Name
+		s.Name = name(s.Name)
 		b.Sources[idx] = s
 	}
 	for idx, s := range b.Volumes {
-		s.Name = b.Name + sep + s.Name
+		s.Name = name(s.Name)
 		b.Volumes[idx] = s
 	}
+	for idx, s := range b.Secrets {
+		s.Name = name(s.Name)
+		b.Secrets[idx] = s
+	}
+	for idx, o := range b.Outputs {
+		o.Source = ref(o.Source)
+		b.Outputs[idx] = o
+	}
 	for idx, s := range b.Steps {
-		s.Name = b.Name + sep + s.Name
-		for idx2, i := range s.Imports {
-			if !strings.Contains(i.Source, sep) {
-				i.Source = b.Name + sep + i.Source
-				s.Imports[idx2] = i
+		s.Name = name(s.Name)
+		for _, mounts := range [][]Mount{s.Imports, s.Exports, s.Volumes} {
+			for i, m := range mounts {
+				m.Source = ref(m.Source)
+				mounts[i] = m
 			}
 		}
-		for idx2, i := range s.Exports {
-			if !strings.Contains(i.Source, sep) {
-				i.Source = b.Name + sep + i.Source
-				s.Exports[idx2] = i
-			}
+		for i, r := range s.Reports {
+			r.Source = ref(r.Source)
+			s.Reports[i] = r
 		}
-		for idx2, i := range s.Volumes {
-			if !strings.Contains(i.Source, sep) {
-				i.Source = b.Name + sep + i.Source
-				s.Volumes[idx2] = i
-			}
+		for i, secret := range s.Secrets {
+			secret.Name = ref(secret.Name)
+			s.Secrets[i] = secret
+		}
+		if s.Context != "" {
+			s.Context = ref(s.Context)
 		}
 		b.Steps[idx] = s
 	}
+	return err
+}
+
+// checkNames returns an error if a name declared by the build contains the
+// namespace separator. Names are checked after they are rendered and before
+// they are namespaced.
+func checkNames(b *Build) error {
+	check := func(kind, name string) error {
+		if strings.Contains(name, namespaceSep) {
+			return fmt.Errorf("%s %s: names can not contain %q", kind, name, namespaceSep)
+		}
+		return nil
+	}
+	if err := check("build", b.Name); err != nil {
+		return err
+	}
+	for _, src := range b.Sources {
+		if err := check("source", src.Name); err != nil {
+			return err
+		}
+	}
+	for _, vol := range b.Volumes {
+		if err := check("volume", vol.Name); err != nil {
+			return err
+		}
+	}
+	for _, secret := range b.Secrets {
+		if err := check("secret", secret.Name); err != nil {
+			return err
+		}
+	}
+	for _, s := range b.Steps {
+		if err := check("step", s.Name); err != nil {
+			return err
+		}
+		for _, exp := range s.Exports {
+			if err := check("source", exp.Source); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setDir sets the directory of the build file, relative to the directory of
+// the main build file, on everything declared with a path.
+func setDir(b *Build, dir string) {
+	if dir == "." {
+		return
+	}
+	for i := range b.Sources {
+		b.Sources[i].Dir = dir
+	}
+	for i := range b.Volumes {
+		b.Volumes[i].Dir = dir
+	}
+	for i := range b.Outputs {
+		b.Outputs[i].Dir = dir
+	}
+	for i := range b.Secrets {
+		b.Secrets[i].Dir = dir
+	}
+	for _, s := range b.Steps {
+		if s.Build != nil {
+			s.Build.Dir = dir
+		}
+	}
 }
 
 // Read will read a build.
-func Read(filename string) (Build, error) {
-	main, err := readBuild(filename)
-	if err != nil {
-		return main, err
+func Read(filename string) (Build, error) { return Load(filename, LoadOptions{}) }
+
+// LoadOptions configure how a build is loaded.
+type LoadOptions struct {
+	// Vars override the defaults of the variables declared by the build.
+	Vars map[string]string
+
+	// BuildID is set on the build and available to templates as
+	// {{ .Build.ID }}.
+	BuildID string
+
+	// Git provides the git metadata available to templates, by default git
+	// is run in the directory of the build file.
+	Git template.GitProvider
+}
+
+// Load reads a build and the builds it requires. Declared variables are
+// overridden by opts.Vars and every build file is templated once, template
+// errors name the file and field they came from.
+func Load(filename string, opts LoadOptions) (Build, error) {
+	files := []*buildFile{}
+	if err := readAll(filename, nil, &files); err != nil {
+		return Build{}, err
 	}
+	main := files[0].build
 
-	for _, filename := range main.Requires {
-		b, err := readBuild(filename)
+	names := map[string]string{}
+	for _, f := range files {
+		if prev, ok := names[f.build.Name]; ok {
+			return *main, fmt.Errorf("builds %s and %s have the same name %s",
+				prev, f.filename, f.build.Name)
+		}
+		names[f.build.Name] = f.filename
+	}
+
+	// Variables declared by the main build take precedence over the defaults
+	// of required builds, and builds over the builds they require.
+	merged := map[string]string{}
+	for i := len(files) - 1; i >= 0; i-- {
+		for k, v := range files[i].build.Vars {
+			merged[k] = v
+		}
+	}
+	for k, v := range opts.Vars {
+		if _, ok := merged[k]; !ok {
+			return *main, fmt.Errorf("variable %s is not declared", k)
+		}
+		merged[k] = v
+	}
+
+	git := opts.Git
+	if git == nil {
+		git = template.ExecGit(filepath.Dir(filename))
+	}
+	data := template.NewData(git, merged)
+	data.Build = template.BuildInfo{ID: opts.BuildID, Name: main.Name}
+
+	for _, f := range files {
+		f.build.Vars = merged
+		data.Dir = filepath.Dir(f.filename)
+		if err := render(f.build, data); err != nil {
+			return *main, fmt.Errorf("%s: %v", f.filename, err)
+		}
+		if err := checkNames(f.build); err != nil {
+			return *main, fmt.Errorf("%s: %v", f.filename, err)
+		}
+		if err := namespace(f.build, main.Name, names); err != nil {
+			return *main, fmt.Errorf("%s: %v", f.filename, err)
+		}
+
+		dir, err := filepath.Rel(filepath.Dir(files[0].abs), filepath.Dir(f.abs))
 		if err != nil {
-			return main, err
+			return *main, err
 		}
-		bp := &b
-		addNamespace(bp)
+		setDir(f.build, filepath.ToSlash(dir))
+	}
 
+	for _, f := range files[1:] {
+		bp := f.build
 		main.Volumes = append(main.Volumes, bp.Volumes...)
 		main.Steps = append(main.Steps, bp.Steps...)
 		main.Sources = append(main.Sources, bp.Sources...)
+		main.Outputs = append(main.Outputs, bp.Outputs...)
+		main.Secrets = append(main.Secrets, bp.Secrets...)
+	}
+	main.ID = opts.BuildID
+
+	return *main, validate(*main)
+}
+
+// render templates every field of the build, steps are rendered with their
+// name set in the template data and can only reference the inputs they
+// declare. The rest of the build can only reference the inputs declared by
+// the build. Steps with a
+// matrix are expanded first and every combination is rendered with its
+// values.
+func render(b *Build, data template.Data) error {
+	vars, steps := b.Vars, b.Steps
+	b.Vars, b.Steps = nil, nil
+	defer func() { b.Vars = vars }()
+
+	rendered := []Step{}
+	for i, step := range steps {
+		in, err := templateInputs(step.Inputs)
+		if err != nil {
+			return fmt.Errorf("step %s: %v", step.Name, err)
+		}
+		expanded, err := expandMatrix(step)
+		if err != nil {
+			return err
+		}
+		for _, s := range expanded {
+			data := data
+			data.Inputs = in
+			data.Step = template.StepInfo{Name: step.Name}
+			data.Matrix = s.values
+			if err := template.Render(&s.Step, data); err != nil {
+				return fmt.Errorf("steps[%d].%v", i, err)
+			}
+			rendered = append(rendered, s.Step)
+		}
+	}
+	in, err := templateInputs(b.Inputs)
+	if err != nil {
+		return err
+	}
+	data.Inputs = in
+	if err := template.Render(b, data); err != nil {
+		return err
+	}
+	b.Steps = rendered
+	return nil
+}
+
+// ReadVars reads variable overrides from a YAML or JSON file of names to
+// values.
+func ReadVars(filename string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("invalid var file %s: %v", filename, err)
+	}
+	return vars, nil
+}
+
+// templateInputs returns the template inputs of declared inputs, nil inputs
+// allow no values. References to values that are not declared fail when the
+// template is rendered.
+func templateInputs(in *Inputs) (*template.Inputs, error) {
+	if in == nil {
+		return &template.Inputs{}, nil
+	}
+	if err := checkInputs(*in); err != nil {
+		return nil, err
+	}
+	return &template.Inputs{Env: in.Env, Git: in.Git, Build: in.Build}, nil
+}
+
+// checkInputs returns an error if inputs are declared that do not exist.
+func checkInputs(in Inputs) error {
+	for _, key := range in.Git {
+		if !containsFold(template.GitKeys, key) {
+			return fmt.Errorf("unknown git input %s", key)
+		}
+	}
+	for _, key := range in.Build {
+		if key != "id" && key != "now" {
+			return fmt.Errorf("unknown build input %s", key)
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks constraints between fields that the schema can not express.
+func validate(b Build) error {
+	steps := map[string]bool{}
+	sources := map[string]bool{}
+	volumes := map[string]bool{}
+	secrets := map[string]bool{}
+	unique := func(m map[string]bool, kind, name string) error {
+		if m[name] {
+			return fmt.Errorf("%s %s is declared more than once", kind, name)
+		}
+		m[name] = true
+		return nil
+	}
+	for _, src := range b.Sources {
+		if err := unique(sources, "source", src.Name); err != nil {
+			return err
+		}
+	}
+	for _, vol := range b.Volumes {
+		if err := unique(volumes, "volume", vol.Name); err != nil {
+			return err
+		}
+	}
+	for _, secret := range b.Secrets {
+		if err := unique(secrets, "secret", secret.Name); err != nil {
+			return err
+		}
+	}
+	for _, s := range b.Steps {
+		if err := unique(steps, "step", s.Name); err != nil {
+			return err
+		}
+		for _, exp := range s.Exports {
+			if err := unique(sources, "source", exp.Source); err != nil {
+				return err
+			}
+		}
 	}
 
-	return main, err
+	for _, s := range b.Steps {
+		if s.SSH && s.Commits() {
+			return fmt.Errorf("step %s: ssh can not be used by steps that commit an image", s.Name)
+		}
+		for _, arg := range s.Args {
+			if !strings.Contains(arg, "=") {
+				return fmt.Errorf(
+					"step %s: build arg %s has no value, use %s={{ .Environ.%s }} with inputs.env",
+					s.Name, arg, arg, arg)
+			}
+		}
+		for _, src := range s.Sources() {
+			if sources[src] {
+				continue
+			}
+			if renamed, ok := b.Renamed(src); ok {
+				return fmt.Errorf("step %s: source %s is not declared or exported, did you mean %s", s.Name, src, renamed)
+			}
+			return fmt.Errorf("step %s: source %s is not declared or exported", s.Name, src)
+		}
+		for _, vol := range s.Volumes {
+			if !volumes[vol.Source] {
+				return fmt.Errorf("step %s: volume %s is not declared", s.Name, vol.Source)
+			}
+		}
+		for _, secret := range s.Secrets {
+			if !secrets[secret.Name] {
+				return fmt.Errorf("step %s: secret %s is not declared", s.Name, secret.Name)
+			}
+		}
+		for _, report := range s.Reports {
+			if !s.Exported(report.Source) {
+				return fmt.Errorf("step %s: report source %s is not exported by the step", s.Name, report.Source)
+			}
+		}
+	}
+	for _, out := range b.Outputs {
+		if !sources[out.Source] {
+			return fmt.Errorf("output %s: source is not declared or exported", out.Source)
+		}
+	}
+	return nil
 }
@@ -1,8 +1,10 @@
 package builder
 
 import (
+	"os"
 	"testing"
 
+	"github.com/coldog/bld/pkg/template"
 	"github.com/stretchr/testify/require"
 )
 
@@ -11,12 +13,317 @@ func TestRead(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("StepExists", func(t *testing.T) {
-		_, exists := b.Step("sub_install")
+		_, exists := b.Step("sub.install")
 		require.True(t, exists)
 	})
 
 	t.Run("SourceExists", func(t *testing.T) {
-		_, exists := b.Source("sub_deps")
+		_, exists := b.Source("sub.deps")
 		require.True(t, exists)
 	})
+
+	t.Run("Reference", func(t *testing.T) {
+		step, exists := b.Step("sub:install")
+		require.True(t, exists)
+		require.Equal(t, "sub.install", step.Name)
+		require.Equal(t, "sub.deps", step.Imports[0].Source)
+	})
+
+	t.Run("Renamed", func(t *testing.T) {
+		renamed, ok := b.Renamed("sub_install")
+		require.True(t, ok)
+		require.Equal(t, "sub.install", renamed)
+
+		_, ok = b.Renamed("sub_nope")
+		require.False(t, ok)
+	})
+}
+
+func TestCheckNames(t *testing.T) {
+	require.NoError(t, checkNames(&Build{
+		Name:  "test",
+		Steps: []Step{{Name: "build_bin", Exports: []Mount{{Source: "bin"}}}},
+	}))
+	require.Error(t, checkNames(&Build{Name: "test.v1"}))
+	require.Error(t, checkNames(&Build{Name: "test", Secrets: []Secret{{Name: "a.b"}}}))
+
+	err := checkNames(&Build{
+		Name:  "test",
+		Steps: []Step{{Name: "build", Exports: []Mount{{Source: "bin.linux"}}}},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bin.linux")
+}
+
+func TestReadRequires(t *testing.T) {
+	b, err := Read("testdata/requires/.bld.yaml")
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, step := range b.Steps {
+		names = append(names, step.Name)
+	}
+	// Builds are read once even when they are required twice.
+	require.Equal(t, []string{"test", "api.build_bin", "lib.install", "web.build_bin"}, names)
+
+	step, _ := b.Step("test")
+	require.Equal(t, []string{"api.bin", "web.bin"}, step.Sources())
+
+	step, _ = b.Step("api:build_bin")
+	require.Equal(t, []string{"lib.node_modules"}, step.Sources())
+	require.Equal(t, "api.bin", step.Exports[0].Source)
+
+	// Paths in required builds are relative to their own directory.
+	src, _ := b.Source("api:src")
+	require.Equal(t, "services/api", src.Dir)
+	require.Equal(t, "services/api", b.Volumes[0].Dir)
+	step, _ = b.Step("api:build_bin")
+	require.Equal(t, "services/api", step.Build.Dir)
+
+	_, err = Read("testdata/requires-unknown.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "nope:x")
+
+	_, err = Read("testdata/requires/cycle/a.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cycle")
+}
+
+func TestReadDockerfile(t *testing.T) {
+	b, err := Read("testdata/dockerfile.yaml")
+	require.NoError(t, err)
+
+	step, exists := b.Step("image")
+	require.True(t, exists)
+	require.True(t, step.Commits())
+	require.Equal(t, []string{"app"}, step.Sources())
+}
+
+func TestReadSecrets(t *testing.T) {
+	b, err := Read("testdata/secrets.yaml")
+	require.NoError(t, err)
+
+	secret, exists := b.Secret("npm")
+	require.True(t, exists)
+	require.Equal(t, "NPM_TOKEN", secret.Env)
+
+	step, exists := b.Step("install")
+	require.True(t, exists)
+	require.Len(t, step.Secrets, 2)
+
+	// Secrets are not part of the digest.
+	digest := step.Digest()
+	step.Secrets = nil
+	require.Equal(t, digest, step.Digest())
+}
+
+func TestReadSSH(t *testing.T) {
+	_, err := Read("testdata/ssh.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "step image")
+
+	step := Step{Name: "install", SSH: true}
+	digest := step.Digest()
+	step.SSH = false
+	require.Equal(t, digest, step.Digest())
+}
+
+func TestReadMatrix(t *testing.T) {
+	b, err := Read("testdata/matrix.yaml")
+	require.NoError(t, err)
+	require.Len(t, b.Steps, 2)
+
+	step, exists := b.Step("test_node-8_os-alpine")
+	require.True(t, exists)
+	require.Equal(t, "node:8", step.Image)
+	require.Equal(t, "coverage_node-8_os-alpine", step.Exports[0].Source)
+	require.Equal(t, "coverage_node-8_os-alpine", step.Reports[0].Source)
+	require.Nil(t, step.Matrix)
+
+	other, exists := b.Step("test_node-10_os-alpine")
+	require.True(t, exists)
+	require.Equal(t, "node:10", other.Image)
+	require.Equal(t, []string{"npm test -- --node 10"}, other.Commands)
+	require.Equal(t, []string{"npm test -- --node 8"}, step.Commands)
+	require.NotEqual(t, step.Digest(), other.Digest())
+}
+
+func TestExpandMatrix(t *testing.T) {
+	steps, err := expandMatrix(Step{
+		Name:   "test",
+		Matrix: map[string]MatrixValues{"node": {"lts/carbon", "1.10"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "test_node-lts-carbon", steps[0].Name)
+	require.Equal(t, "test_node-1-10", steps[1].Name)
+	require.Equal(t, map[string]string{"node": "1.10"}, steps[1].values)
+
+	_, err = expandMatrix(Step{
+		Name:   "test",
+		Matrix: map[string]MatrixValues{"node": {"lts/carbon", "lts_carbon"}},
+	})
+	require.Error(t, err)
+}
+
+func TestRenderMatrix(t *testing.T) {
+	b := Build{Steps: []Step{{
+		Name:  "test",
+		Image: `{{ .Matrix.node | printf "node:%s" }}`,
+		Commands: []string{
+			`{{ if eq .Matrix.node "8" }}legacy{{ else }}current{{ end }}`,
+		},
+		Matrix: map[string]MatrixValues{"node": {"8", "10"}},
+	}}}
+	require.NoError(t, render(&b, template.NewData(nil, nil)))
+	require.Len(t, b.Steps, 2)
+	require.Equal(t, "node:8", b.Steps[0].Image)
+	require.Equal(t, []string{"legacy"}, b.Steps[0].Commands)
+	require.Equal(t, "node:10", b.Steps[1].Image)
+	require.Equal(t, []string{"current"}, b.Steps[1].Commands)
+
+	b = Build{Steps: []Step{{
+		Name:   "test",
+		Image:  "node:{{ .Matrix.version }}",
+		Matrix: map[string]MatrixValues{"node": {"8"}},
+	}}}
+	err := render(&b, template.NewData(nil, nil))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "steps[0].image")
+}
+
+func TestValidate(t *testing.T) {
+	b := Build{
+		Sources: []Source{{Name: "app"}},
+		Steps: []Step{
+			{
+				Name:    "build",
+				Imports: []Mount{{Source: "app"}},
+				Exports: []Mount{{Source: "dist"}},
+				Reports: []Report{{Source: "dist", Path: "*.xml"}},
+			},
+			{Name: "test", Imports: []Mount{{Source: "dist"}}},
+		},
+		Outputs: []Output{{Source: "dist"}},
+	}
+	require.NoError(t, validate(b))
+
+	b.Steps[1].Imports[0].Source = "missing"
+	require.Error(t, validate(b))
+	b.Steps[1].Imports[0].Source = "dist"
+
+	b.Steps[0].Reports[0].Source = "app"
+	require.Error(t, validate(b))
+	b.Steps[0].Reports[0].Source = "dist"
+
+	b.Outputs[0].Source = "missing"
+	require.Error(t, validate(b))
+	b.Outputs[0].Source = "dist"
+
+	// Names must be unique once namespaced and expanded.
+	b.Steps = append(b.Steps, Step{Name: "test"})
+	require.Error(t, validate(b))
+	b.Steps = b.Steps[:2]
+
+	b.Steps[1].Exports = []Mount{{Source: "app"}}
+	require.Error(t, validate(b))
+	b.Steps[1].Exports = nil
+
+	b.Steps[1].Volumes = []Mount{{Source: "cache"}}
+	require.Error(t, validate(b))
+	b.Steps[1].Volumes = nil
+
+	b.Steps[1].Secrets = []SecretMount{{Name: "token"}}
+	require.Error(t, validate(b))
+	b.Steps[1].Secrets = nil
+
+	// Build args are not read from the host environment.
+	b.Steps[1].Args = []string{"VERSION"}
+	require.Error(t, validate(b))
+	b.Steps[1].Args = []string{"VERSION="}
+	require.NoError(t, validate(b))
+}
+
+func TestReadVars(t *testing.T) {
+	b, err := Load("testdata/vars.yaml", LoadOptions{})
+	require.NoError(t, err)
+
+	step, _ := b.Step("test")
+	require.Equal(t, "node:10", step.Image)
+	digest := step.Digest()
+
+	// Unused variables do not change the digest.
+	b, err = Load("testdata/vars.yaml", LoadOptions{Vars: map[string]string{"registry": "gcr.io"}})
+	require.NoError(t, err)
+	step, _ = b.Step("test")
+	require.Equal(t, digest, step.Digest())
+
+	vars, err := ReadVars("testdata/vars-override.yaml")
+	require.NoError(t, err)
+	b, err = Load("testdata/vars.yaml", LoadOptions{Vars: vars})
+	require.NoError(t, err)
+	step, _ = b.Step("test")
+	require.Equal(t, "node:12", step.Image)
+	require.NotEqual(t, digest, step.Digest())
+
+	_, err = Load("testdata/vars.yaml", LoadOptions{Vars: map[string]string{"undeclared": "1"}})
+	require.Error(t, err)
+}
+
+func TestReadRender(t *testing.T) {
+	b, err := Read("testdata/render.yaml")
+	require.NoError(t, err)
+
+	source, _ := b.Source("app")
+	require.Equal(t, "/usr/src/app", source.Target)
+	require.Equal(t, "/usr/src/app/.cache", b.Volumes[0].Target)
+
+	step, _ := b.Step("test")
+	require.Equal(t, `echo "say "hello""`, step.Commands[0])
+
+	_, err = Read("testdata/render-error.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "testdata/render-error.yaml")
+	require.Contains(t, err.Error(), "steps[0].commands[0]")
+}
+
+func TestReadInputs(t *testing.T) {
+	b, err := Read("testdata/inputs.yaml")
+	require.NoError(t, err)
+
+	step, _ := b.Step("test")
+	require.Equal(t, []string{"git.sha"}, step.Volatile())
+
+	_, err = Read("testdata/inputs-undeclared.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "HOME")
+
+	// The inputs of a step are not available to the rest of the build.
+	_, err = Read("testdata/inputs-source.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "sources[0].target")
+
+	b, err = Read("testdata/inputs-build.yaml")
+	require.NoError(t, err)
+	src, _ := b.Source("app")
+	require.Equal(t, os.Getenv("HOME"), src.Target)
+
+	// Steps do not inherit the inputs of the build.
+	step, _ = b.Step("test")
+	require.Empty(t, step.Volatile())
+}
+
+func TestReadMetadata(t *testing.T) {
+	b, err := Load("testdata/metadata.yaml", LoadOptions{
+		BuildID: "1234",
+		Git: func() template.Git {
+			return template.Git{Branch: "feature/Add_Thing"}
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1234", b.ID)
+
+	step, _ := b.Step("image")
+	require.Equal(t, `echo "image"`, step.Commands[0])
+	require.Equal(t, "app:feature-add-thing-1234", step.Build.Tag)
+	require.Equal(t, []string{"build.id"}, step.Volatile())
 }
@@ -1,3 +1,3 @@
 package builder
 
-const schema = `{"$schema":"http://json-schema.org/draft-06/schema#","title":"Build","type":"object","additionalProperties":false,"properties":{"id":{"type":"string"},"requires":{"type":"array","items":{"type":"string"},"uniqueItems":true},"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"volumes":{"type":"array","items":{"$ref":"#/definitions/volume"}},"sources":{"type":"array","items":{"$ref":"#/definitions/source"}},"steps":{"type":"array","items":{"$ref":"#/definitions/step"}}},"definitions":{"volume":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"target":{"type":"string"}},"required":["name","target"]},"source":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"target":{"type":"string"},"files":{"type":"array","items":{"type":"string"},"uniqueItems":true}},"required":["name","target"]},"mount":{"type":"object","properties":{"source":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"mount":{"type":"string"}},"required":["source","mount"]},"image":{"type":"object","properties":{"tag":{"type":"string"},"entrypoint":{"type":"array","items":{"type":"string"}},"env":{"type":"array","items":{"type":"string"}},"workdir":{"type":"string"}},"required":["tag"]},"step":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"image":{"type":"string"},"commands":{"type":"array","items":{"type":"string"}},"imports":{"type":"array","items":{"$ref":"#/definitions/mount"}},"exports":{"type":"array","items":{"$ref":"#/definitions/mount"}},"volumes":{"type":"array","items":{"$ref":"#/definitions/mount"}},"env":{"type":"array","items":{"type":"string"}},"workdir":{"type":"string"},"save":{"$ref":"#/definitions/image"}},"required":["name","image","commands"]}},"required":["name"]}`
+const schema = `{"$schema":"http://json-schema.org/draft-06/schema#","title":"Build","type":"object","additionalProperties":false,"properties":{"id":{"type":"string"},"requires":{"type":"array","items":{"type":"string"},"uniqueItems":true},"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"volumes":{"type":"array","items":{"$ref":"#/definitions/volume"}},"sources":{"type":"array","items":{"$ref":"#/definitions/source"}},"steps":{"type":"array","items":{"$ref":"#/definitions/step"}},"outputs":{"type":"array","items":{"$ref":"#/definitions/output"}},"secrets":{"type":"array","items":{"$ref":"#/definitions/secret"}},"vars":{"type":"object","additionalProperties":{"type":"string"}},"inputs":{"$ref":"#/definitions/inputs"}},"definitions":{"inputs":{"type":"object","additionalProperties":false,"properties":{"env":{"type":"array","items":{"type":"string"}},"git":{"type":"array","items":{"type":"string"}},"build":{"type":"array","items":{"enum":["id","now"]}}}},"volume":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"target":{"type":"string"}},"required":["name","target"]},"source":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"target":{"type":"string"},"files":{"type":"array","items":{"type":"string"},"uniqueItems":true}},"required":["name","target"]},"output":{"type":"object","properties":{"source":{"type":"string","pattern":"^([a-zA-Z\\_\\-]+:)?[a-zA-Z\\_\\-]+$"},"target":{"type":"string"},"clean":{"type":"boolean"},"untracked":{"type":"boolean"}},"required":["source","target"]},"mount":{"type":"object","properties":{"source":{"type":"string","pattern":"^([a-zA-Z\\_\\-]+:)?[a-zA-Z\\_\\-]+$"},"mount":{"type":"string"}},"required":["source","mount"]},"secret":{"type":"object","properties":{"name":{"type":"string","pattern":"^([a-zA-Z\\_\\-]+:)?[a-zA-Z\\_\\-]+$"},"env":{"type":"string"},"file":{"type":"string"}},"required":["name"],"oneOf":[{"required":["env"]},{"required":["file"]}]},"service":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"image":{"type":"string"},"env":{"type":"array","items":{"type":"string"}},"command":{"type":"array","items":{"type":"string"}},"healthcheck":{"type":"object","properties":{"command":{"type":"array","items":{"type":"string"}},"interval":{"type":"string"},"retries":{"type":"integer"}},"required":["command"]}},"required":["name","image"]},"report":{"type":"object","properties":{"source":{"type":"string","pattern":"^([a-zA-Z\\_\\-]+:)?[a-zA-Z\\_\\-]+$"},"path":{"type":"string"}},"required":["source","path"]},"image":{"type":"object","properties":{"tag":{"type":"string"},"entrypoint":{"type":"array","items":{"type":"string"}},"env":{"type":"array","items":{"type":"string"}},"workdir":{"type":"string"},"push":{"anyOf":[{"type":"boolean"},{"type":"array","items":{"type":"string"}}]},"oci":{"type":"string"}},"required":["tag"]},"step":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-zA-Z\\_\\-]+$"},"image":{"type":"string"},"commands":{"type":"array","items":{"type":"string"}},"imports":{"type":"array","items":{"$ref":"#/definitions/mount"}},"exports":{"type":"array","items":{"$ref":"#/definitions/mount"}},"volumes":{"type":"array","items":{"$ref":"#/definitions/mount"}},"reports":{"type":"array","items":{"$ref":"#/definitions/report"}},"secrets":{"type":"array","items":{"$ref":"#/definitions/secret"}},"ssh":{"type":"boolean"},"services":{"type":"array","items":{"$ref":"#/definitions/service"}},"inputs":{"$ref":"#/definitions/inputs"},"matrix":{"type":"object","additionalProperties":{"type":"array","items":{"type":["string","number","boolean"]}}},"env":{"type":"array","items":{"type":"string"}},"workdir":{"type":"string"},"save":{"$ref":"#/definitions/image"},"dockerfile":{"type":"string"},"context":{"type":"string","pattern":"^([a-zA-Z\\_\\-]+:)?[a-zA-Z\\_\\-]+$"},"args":{"type":"array","items":{"type":"string"}}},"required":["name"],"anyOf":[{"required":["image","commands"]},{"required":["dockerfile","context"]}]}},"required":["name"]}`
@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coldog/bld/pkg/fileutils"
+	"github.com/coldog/bld/pkg/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Labels applied to every container and network created by the executor.
+const (
+	labelBuildID  = "bld.build-id"
+	labelBuildDir = "bld.build-dir"
+	labelStep     = "bld.step"
+	labelDigest   = "bld.digest"
+	labelVersion  = "bld.version"
+)
+
+const (
+	heartbeatInterval = 5 * time.Second
+	heartbeatTimeout  = 6 * heartbeatInterval
+)
+
+func lockDir(buildDir string) string { return buildDir + "/builds" }
+
+func lockFile(buildDir, buildID string) string {
+	return lockDir(buildDir) + "/" + buildID + ".lock"
+}
+
+// Heartbeat writes a lock file for the build and touches it until the
+// returned stop function is called. Builds without a recent heartbeat are
+// considered dead by Cleanup.
+func Heartbeat(buildDir, buildID string) (func(), error) {
+	file := lockFile(buildDir, buildID)
+	if err := os.MkdirAll(filepath.Dir(file), fileutils.Directory); err != nil {
+		return nil, err
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	if err := ioutil.WriteFile(file, pid, fileutils.Regular); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case t := <-ticker.C:
+				os.Chtimes(file, t, t)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		os.Remove(file)
+	}, nil
+}
+
+// Running returns true if the build has a recent heartbeat.
+func Running(buildDir, buildID string) bool {
+	info, err := os.Stat(lockFile(buildDir, buildID))
+	if err != nil {
+		return false
+	}
+	return time.Since(info.ModTime()) < heartbeatTimeout
+}
+
+// Cleanup removes containers and networks created by bld for builds in buildDir
+// that are no longer running. It returns the removed container IDs.
+func (e *Executor) Cleanup(ctx context.Context, buildDir string) ([]string, error) {
+	logger := log.ContextGetLogger(ctx)
+
+	args := filters.NewArgs()
+	args.Add("label", labelBuildDir+"="+buildDir)
+	containers, err := e.client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, ct := range orphanedContainers(buildDir, containers) {
+		buildID := ct.Labels[labelBuildID]
+		logger.V(2).Printf(
+			"removing orphaned container id=%s build=%s step=%s",
+			ct.ID, buildID, ct.Labels[labelStep],
+		)
+		if err := e.client.ContainerRemove(ctx, ct.ID, types.ContainerRemoveOptions{
+			Force: true,
+		}); err != nil {
+			return removed, err
+		}
+		removed = append(removed, ct.ID)
+	}
+
+	// Networks are created for steps with services.
+	networks, err := e.client.NetworkList(ctx, types.NetworkListOptions{
+		Filters: args,
+	})
+	if err != nil {
+		return removed, err
+	}
+	for _, n := range orphanedNetworks(buildDir, networks) {
+		buildID := n.Labels[labelBuildID]
+		logger.V(2).Printf("removing orphaned network id=%s build=%s", n.ID, buildID)
+		if err := e.client.NetworkRemove(ctx, n.ID); err != nil {
+			return removed, err
+		}
+	}
+
+	removeStaleLocks(buildDir)
+	return removed, nil
+}
+
+// orphaned returns true if the resource belongs to a build that has no recent
+// heartbeat, either because its lock is stale or because it has none.
+func orphaned(buildDir string, labels map[string]string) bool {
+	return !Running(buildDir, labels[labelBuildID])
+}
+
+func orphanedContainers(buildDir string, containers []types.Container) []types.Container {
+	orphans := []types.Container{}
+	for _, ct := range containers {
+		if orphaned(buildDir, ct.Labels) {
+			orphans = append(orphans, ct)
+		}
+	}
+	return orphans
+}
+
+func orphanedNetworks(buildDir string, networks []types.NetworkResource) []types.NetworkResource {
+	orphans := []types.NetworkResource{}
+	for _, n := range networks {
+		if orphaned(buildDir, n.Labels) {
+			orphans = append(orphans, n)
+		}
+	}
+	return orphans
+}
+
+// removeStaleLocks removes the lock files of builds that are gone.
+func removeStaleLocks(buildDir string) {
+	files, _ := ioutil.ReadDir(lockDir(buildDir))
+	for _, f := range files {
+		buildID := strings.TrimSuffix(f.Name(), ".lock")
+		if !Running(buildDir, buildID) {
+			os.Remove(lockFile(buildDir, buildID))
+		}
+	}
+}
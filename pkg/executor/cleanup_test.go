@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+
+	require.False(t, Running(tmp, "build-1"))
+
+	stop, err := Heartbeat(tmp, "build-1")
+	require.Nil(t, err)
+	require.True(t, Running(tmp, "build-1"))
+
+	stop()
+	require.False(t, Running(tmp, "build-1"))
+}
+
+func TestOrphaned(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmp)
+
+	stop, err := Heartbeat(tmp, "running")
+	require.Nil(t, err)
+	defer stop()
+
+	// A build whose process died leaves a lock that is no longer touched.
+	require.Nil(t, ioutil.WriteFile(lockFile(tmp, "stale"), nil, 0644))
+	old := time.Now().Add(-2 * heartbeatTimeout)
+	require.Nil(t, os.Chtimes(lockFile(tmp, "stale"), old, old))
+
+	containers := []types.Container{
+		{ID: "c1", Labels: map[string]string{labelBuildID: "running"}},
+		{ID: "c2", Labels: map[string]string{labelBuildID: "stale"}},
+		{ID: "c3", Labels: map[string]string{labelBuildID: "absent"}},
+		{ID: "c4", Labels: map[string]string{}},
+	}
+	ids := []string{}
+	for _, ct := range orphanedContainers(tmp, containers) {
+		ids = append(ids, ct.ID)
+	}
+	require.Equal(t, []string{"c2", "c3", "c4"}, ids)
+
+	networks := []types.NetworkResource{
+		{ID: "n1", Labels: map[string]string{labelBuildID: "running"}},
+		{ID: "n2", Labels: map[string]string{labelBuildID: "stale"}},
+	}
+	orphans := orphanedNetworks(tmp, networks)
+	require.Len(t, orphans, 1)
+	require.Equal(t, "n2", orphans[0].ID)
+
+	removeStaleLocks(tmp)
+	_, err = os.Stat(lockFile(tmp, "stale"))
+	require.True(t, os.IsNotExist(err))
+	require.True(t, Running(tmp, "running"))
+}
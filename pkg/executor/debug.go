@@ -0,0 +1,130 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/coldog/bld/pkg/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/strslice"
+)
+
+const debugShell = "/bin/sh"
+
+func (e *Executor) debugRef(step builder.StepExec) string {
+	return "bld-debug-" + strings.ToLower(step.Name) + ":" + step.Digest
+}
+
+// Debug commits the stopped container and opens an interactive shell in a new
+// container created from it with the same binds, secrets, network, env,
+// workdir and user as the step. Both the container and the image are removed
+// when the shell exits.
+func (e *Executor) debug(
+	ctx context.Context,
+	id string,
+	step builder.StepExec,
+	res stepResources,
+) error {
+	logger := log.ContextGetLogger(ctx)
+	ref := e.debugRef(step)
+
+	logger.Printf("opening debug shell for %s, exit the shell to continue", step.Name)
+	if _, err := e.client.ContainerCommit(ctx, id, types.ContainerCommitOptions{
+		Reference: ref,
+	}); err != nil {
+		return err
+	}
+	defer e.client.ImageRemove(
+		context.Background(), ref, types.ImageRemoveOptions{PruneChildren: true})
+
+	config, hostConfig, netConfig := e.getConfig(step, res)
+	config.Image = ref
+	config.Entrypoint = strslice.StrSlice{debugShell}
+	if len(step.SecretValues) > 0 {
+		// An interactive sh sources $ENV, which exports the env secrets.
+		config.Env = append(append([]string{}, config.Env...), "ENV="+secretsEnvFile)
+	}
+	config.Tty = true
+	config.OpenStdin = true
+	config.StdinOnce = true
+	config.AttachStdin = true
+	config.AttachStdout = true
+	config.AttachStderr = true
+
+	ct, err := e.client.ContainerCreate(
+		ctx, config, hostConfig, netConfig, step.BuildID+"_"+step.Name+"_debug")
+	if err != nil {
+		return err
+	}
+	defer e.client.ContainerRemove(
+		context.Background(), ct.ID, types.ContainerRemoveOptions{Force: true})
+
+	resp, err := e.client.ContainerAttach(ctx, ct.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	restore, err := rawTerminal()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	if err := e.client.ContainerStart(
+		ctx, ct.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	if height, width, err := terminalSize(); err == nil {
+		e.client.ContainerResize(ctx, ct.ID, types.ResizeOptions{
+			Height: height,
+			Width:  width,
+		})
+	}
+
+	go io.Copy(resp.Conn, os.Stdin)
+	go io.Copy(os.Stdout, resp.Reader)
+
+	_, err = e.waitForExit(ctx, ct.ID)
+	return err
+}
+
+// RawTerminal shells out to stty to put the terminal in raw mode, the returned
+// function restores the previous state.
+func rawTerminal() (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("executor: stdin is not a terminal: %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(state) }, nil
+}
+
+func terminalSize() (uint, uint, error) {
+	out, err := stty("size")
+	if err != nil {
+		return 0, 0, err
+	}
+	var height, width uint
+	_, err = fmt.Sscanf(out, "%d %d", &height, &width)
+	return height, width, err
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,100 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/coldog/bld/pkg/fileutils"
+	"github.com/coldog/bld/pkg/log"
+	"github.com/docker/docker/api/types"
+)
+
+// buildMessage is a single message from the docker build API output stream.
+type buildMessage struct {
+	Stream string `json:"stream"`
+	Error  string `json:"error"`
+}
+
+// buildArgs returns the build args of a step, every arg has a value so that
+// the image only depends on the step digest.
+func buildArgs(args []string) map[string]*string {
+	m := map[string]*string{}
+	for _, arg := range args {
+		spl := strings.SplitN(arg, "=", 2)
+		val := ""
+		if len(spl) == 2 {
+			val = spl[1]
+		}
+		m[spl[0]] = &val
+	}
+	return m
+}
+
+// BuildDockerfile builds the image for a dockerfile step, the context source is
+// sent to the daemon as a gzipped tar archive. The image is tagged the same way
+// as committed containers.
+func (e *Executor) buildDockerfile(ctx context.Context, step builder.StepExec) error {
+	logger := log.ContextGetLogger(ctx)
+
+	if len(step.SecretValues) > 0 {
+		return errors.New("executor: secrets are not supported for dockerfile steps")
+	}
+
+	contextFile := e.execDir(step) + "/" + step.Name + "_context.tar.gz"
+	if err := os.MkdirAll(e.execDir(step), fileutils.Directory); err != nil {
+		return err
+	}
+	defer os.Remove(contextFile)
+
+	logger.V(4).Printf("creating build context source=%s", step.Context)
+	if err := fileutils.Tar(step.SourceDirs[step.Context], contextFile); err != nil {
+		return err
+	}
+	buildContext, err := os.Open(contextFile)
+	if err != nil {
+		return err
+	}
+	defer buildContext.Close()
+
+	ref := step.Name + ":" + step.Digest
+	tags := []string{ref}
+	if step.Build != nil && step.Build.Tag != "" {
+		tags = append(tags, step.Build.Tag)
+	}
+
+	logger.Printf("building %s", step.Dockerfile)
+	resp, err := e.client.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Tags:        tags,
+		Dockerfile:  step.Dockerfile,
+		BuildArgs:   buildArgs(step.Args),
+		Remove:      true,
+		ForceRemove: true,
+		Labels:      e.labels(step),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	w := &lineWriter{l: logger, marker: stdoutMarker, out: step.Output}
+	defer w.Flush()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg buildMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		w.Write([]byte(msg.Stream))
+	}
+}
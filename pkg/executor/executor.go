@@ -7,7 +7,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/coldog/bld/pkg/builder"
 	"github.com/coldog/bld/pkg/fileutils"
@@ -16,15 +17,38 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/strslice"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/moby/moby/client"
 )
 
-const workspaceDir = "/.bld/workspace"
+const (
+	workspaceDir = "/.bld/workspace"
+	sshAuthSock  = "/.bld/ssh-agent.sock"
+
+	defaultStopTimeout = 10 * time.Second
+)
 
 // Executor executes the build steps.
 type Executor struct {
-	client *client.Client
+	// StopTimeout is the grace period given to a running container to exit
+	// when a step is cancelled before it is killed.
+	StopTimeout time.Duration
+
+	// Version of bld, it is recorded as a label on every container.
+	Version string
+
+	// DebugOnFailure opens an interactive shell in the container of a step that
+	// exits with a non-zero code.
+	DebugOnFailure bool
+
+	// ShellStep opens an interactive shell in the container of the named step
+	// once its commands have run.
+	ShellStep string
+
+	// SSHAuthSock is the host SSH agent socket forwarded to steps with ssh.
+	SSHAuthSock string
+
+	client    *client.Client
+	debugLock sync.Mutex
 }
 
 // Open will initialize the executor and open a docker client.
@@ -80,11 +104,22 @@ func (e *Executor) getBinds(step builder.StepExec) []string {
 	for _, v := range step.Volumes {
 		binds = append(binds, step.SourceDirs[v.Source]+":"+v.Mount)
 	}
+	if step.SSH {
+		binds = append(binds, e.SSHAuthSock+":"+sshAuthSock)
+	}
 	return binds
 }
 
+// stepResources are created for a step before its container, they are shared
+// by the step container and its debug shell.
+type stepResources struct {
+	secretBinds []string
+	network     string
+}
+
 func (e *Executor) getConfig(
 	step builder.StepExec,
+	res stepResources,
 ) (*container.Config, *container.HostConfig, *network.NetworkingConfig) {
 	binds := e.getBinds(step)
 	entrypoint := e.entrypointFile(step)
@@ -97,14 +132,33 @@ func (e *Executor) getConfig(
 		},
 		WorkingDir: step.Workdir,
 		Env:        step.Env,
+		Labels:     e.labels(step),
+	}
+	if step.SSH {
+		// Steps with ssh never commit, so the socket path is never saved in an
+		// image config.
+		config.Env = append(append([]string{}, step.Env...), "SSH_AUTH_SOCK="+sshAuthSock)
 	}
 	hostConfig := &container.HostConfig{
-		Binds: binds,
+		Binds: append(binds, res.secretBinds...),
+	}
+	if res.network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(res.network)
 	}
 	netConfig := &network.NetworkingConfig{}
 	return config, hostConfig, netConfig
 }
 
+func (e *Executor) labels(step builder.StepExec) map[string]string {
+	return map[string]string{
+		labelBuildID:  step.BuildID,
+		labelBuildDir: step.BuildDir,
+		labelStep:     step.Name,
+		labelDigest:   step.Digest,
+		labelVersion:  e.Version,
+	}
+}
+
 func (e *Executor) startContainer(
 	ctx context.Context,
 	step builder.StepExec,
@@ -119,7 +173,7 @@ func (e *Executor) startContainer(
 	}
 	if err := e.client.ContainerStart(
 		ctx, ct.ID, types.ContainerStartOptions{}); err != nil {
-		return "", err
+		return ct.ID, err
 	}
 	return ct.ID, nil
 }
@@ -154,6 +208,24 @@ func (e *Executor) remove(ctx context.Context, id string) error {
 	return err
 }
 
+// Stop will stop and remove a container, it is used when the step context is
+// cancelled so it runs under a new context.
+func (e *Executor) stop(id string) error {
+	timeout := e.StopTimeout
+	if timeout == 0 {
+		timeout = defaultStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*timeout)
+	defer cancel()
+
+	if err := e.client.ContainerStop(ctx, id, &timeout); err != nil {
+		return err
+	}
+	return e.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{
+		Force: true,
+	})
+}
+
 func (e *Executor) waitForExit(ctx context.Context, id string) (int, error) {
 	if _, err := e.client.ContainerWait(ctx, id); err != nil {
 		return 0, err
@@ -184,18 +256,46 @@ func (e *Executor) Execute(ctx context.Context, step builder.StepExec) error {
 
 	logger := log.ContextGetLogger(ctx)
 
+	if step.Dockerfile != "" {
+		return e.buildDockerfile(ctx, step)
+	}
+
 	logger.Printf("pulling %s", step.Image)
 	if err := e.pullImage(ctx, step.Image); err != nil {
 		return err
 	}
 
+	if step.SSH && e.SSHAuthSock == "" {
+		return fmt.Errorf("executor: ssh agent is not available for step %s", step.Name)
+	}
+
+	secretBinds, err := e.writeSecrets(step)
+	defer e.removeSecrets(step)
+	if err != nil {
+		return err
+	}
+
+	commands := step.Commands
+	if len(step.SecretValues) > 0 {
+		commands = append([]string{". " + secretsEnvFile}, commands...)
+	}
+
 	logger.V(5).Printf("building entrypoint entrypoint=%s", entrypoint)
 	if err := buildEntrypoint(
-		execDir+"/"+entrypoint, step.Commands); err != nil {
+		execDir+"/"+entrypoint, commands); err != nil {
 		return err
 	}
 
-	config, hostConfig, netConfig := e.getConfig(step)
+	res := stepResources{secretBinds: secretBinds}
+	if len(step.Services) > 0 {
+		networkName, stopServices, err := e.startServices(ctx, step)
+		if err != nil {
+			return err
+		}
+		defer stopServices()
+		res.network = networkName
+	}
+	config, hostConfig, netConfig := e.getConfig(step, res)
 
 	var id string
 	logger.V(5).Printf("creating container name=%v container=%+v host=%+v",
@@ -203,23 +303,60 @@ func (e *Executor) Execute(ctx context.Context, step builder.StepExec) error {
 	{
 		var err error
 		id, err = e.startContainer(ctx, step, config, hostConfig, netConfig)
+		if id != "" {
+			// The container is removed on every path that returns before it
+			// is removed below, including a cancelled start.
+			defer func() {
+				if id == "" {
+					return
+				}
+				logger.V(4).Printf("removing container id=%s", id)
+				if serr := e.stop(id); serr != nil {
+					logger.Printf("failed to stop container id=%s: %v", id, serr)
+				}
+			}()
+		}
 		if err != nil {
 			return err
 		}
 	}
 
 	logger.V(4).Printf("container started id=%s", id)
-	go e.logs(ctx, logger, id)
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		if err := e.logs(ctx, logger, id, step.Output); err != nil {
+			logger.V(2).Printf("failed to stream logs id=%s: %v", id, err)
+		}
+	}()
 
 	var exitCode int
 	{
 		var err error
 		exitCode, err = e.waitForExit(ctx, id)
 		if err != nil {
+			logger.Printf("stopping container id=%s: %v", id, err)
+			if serr := e.stop(id); serr != nil {
+				logger.Printf("failed to stop container id=%s: %v", id, serr)
+			}
+			id = ""
+			<-logsDone
 			return err
 		}
 	}
 
+	// The log stream ends when the container exits, wait for it to be drained
+	// before the container is removed.
+	<-logsDone
+
+	if (e.DebugOnFailure && exitCode != 0) || e.ShellStep == step.Name {
+		e.debugLock.Lock()
+		if err := e.debug(ctx, id, step, res); err != nil {
+			logger.Printf("debug shell failed: %v", err)
+		}
+		e.debugLock.Unlock()
+	}
+
 	if step.Build != nil {
 		if err := e.commit(ctx, id, step); err != nil {
 			return err
@@ -228,42 +365,15 @@ func (e *Executor) Execute(ctx context.Context, step builder.StepExec) error {
 	if err := e.remove(ctx, id); err != nil {
 		return err
 	}
+	id = ""
 
 	logger.V(4).Printf("container finished code=%v", exitCode)
 	if exitCode != 0 {
-		return fmt.Errorf("container: exit code %d", exitCode)
+		return builder.ExitError{Code: exitCode}
 	}
 	return nil
 }
 
-func (e *Executor) logs(
-	ctx context.Context, l log.Logger, id string) error {
-	reader, err := e.client.ContainerLogs(ctx, id, types.ContainerLogsOptions{
-		Follow:     true,
-		ShowStderr: true,
-		ShowStdout: true,
-	})
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
-	w := &dockerWriter{id: id, l: l}
-	_, err = stdcopy.StdCopy(w, w, reader)
-	return err
-}
-
-type dockerWriter struct {
-	id string
-	l  log.Logger
-}
-
-func (d *dockerWriter) Write(b []byte) (int, error) {
-	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
-		d.l.Printf("> %s", line)
-	}
-	return len(b), nil
-}
-
 func buildEntrypoint(file string, commands []string) error {
 	if err := os.MkdirAll(filepath.Dir(file), fileutils.Directory); err != nil {
 		return err
@@ -106,3 +106,46 @@ func TestCommit(t *testing.T) {
 		BuildID: uuid.NewV4().String(),
 	})
 }
+
+func TestDockerfile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+
+	err = ioutil.WriteFile(tmp+"/Dockerfile", []byte(
+		"FROM alpine\nARG GREETING\nRUN echo $GREETING > /test.txt\n",
+	), fileutils.Regular)
+	require.Nil(t, err)
+
+	test(t, builder.StepExec{
+		Digest: "asfkjsadflkjsadfd",
+		Step: builder.Step{
+			Name:       "test",
+			Dockerfile: "Dockerfile",
+			Context:    "app",
+			Args:       []string{"GREETING=hello"},
+		},
+		BuildID: uuid.NewV4().String(),
+		SourceDirs: map[string]string{
+			"app": tmp,
+		},
+	})
+}
+
+func TestGetConfig(t *testing.T) {
+	e := &Executor{}
+	step := builder.StepExec{
+		Step:     builder.Step{Name: "test", Image: "alpine"},
+		BuildID:  "1",
+		BuildDir: "/tmp/bld",
+	}
+
+	_, hostConfig, _ := e.getConfig(step, stepResources{})
+	require.Empty(t, string(hostConfig.NetworkMode))
+
+	_, hostConfig, _ = e.getConfig(step, stepResources{
+		secretBinds: []string{"/dev/shm/bld/token:/run/secrets/token:ro"},
+		network:     "bld_1_test",
+	})
+	require.Contains(t, hostConfig.Binds, "/dev/shm/bld/token:/run/secrets/token:ro")
+	require.Equal(t, "bld_1_test", string(hostConfig.NetworkMode))
+}
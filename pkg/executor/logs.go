@@ -0,0 +1,103 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coldog/bld/pkg/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Markers printed in front of each line of container output.
+const (
+	stdoutMarker = ">"
+	stderrMarker = "!"
+)
+
+// Logs streams the output of a container until it exits. Each stream is
+// printed line by line, output is written to out if it is set.
+func (e *Executor) logs(
+	ctx context.Context, l log.Logger, id string, out io.Writer) error {
+	reader, err := e.client.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		Follow:     true,
+		ShowStderr: true,
+		ShowStdout: true,
+		Timestamps: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	stdout := &lineWriter{l: l, marker: stdoutMarker, out: out}
+	stderr := &lineWriter{l: l, marker: stderrMarker, out: out}
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	return err
+}
+
+// lineWriter buffers a stream of container output and only prints complete
+// lines. Lines are expected to be prefixed with the container timestamp.
+type lineWriter struct {
+	l      log.Logger
+	marker string
+	out    io.Writer
+	buf    []byte
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush prints any remaining partial line.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) line(line string) {
+	line = strings.TrimSuffix(line, "\r")
+	ts, msg := splitTimestamp(line)
+	msg = log.Redact(msg)
+	if ts.IsZero() {
+		w.l.Printf("%s %s", w.marker, msg)
+	} else {
+		w.l.Printf("%s %s %s", w.marker, ts.Format("15:04:05.000"), msg)
+	}
+	if w.out != nil {
+		io.WriteString(w.out, msg+"\n")
+	}
+}
+
+// splitTimestamp splits the RFC3339 timestamp added by docker from a line.
+func splitTimestamp(line string) (time.Time, string) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		if ts, err := time.Parse(time.RFC3339Nano, line); err == nil {
+			return ts, ""
+		}
+		return time.Time{}, line
+	}
+	ts, err := time.Parse(time.RFC3339Nano, line[:i])
+	if err != nil {
+		return time.Time{}, line
+	}
+	return ts, line[i+1:]
+}
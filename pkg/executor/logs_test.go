@@ -0,0 +1,30 @@
+package executor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coldog/bld/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineWriter(t *testing.T) {
+	logged := bytes.NewBuffer(nil)
+	out := bytes.NewBuffer(nil)
+	w := &lineWriter{
+		l:      log.Logger{}.Output(logged),
+		marker: stderrMarker,
+		out:    out,
+	}
+
+	w.Write([]byte("2018-06-01T10:00:00.123456789Z hel"))
+	require.Equal(t, "", logged.String())
+
+	w.Write([]byte("lo\n2018-06-01T10:00:01.5Z wor"))
+	require.Contains(t, logged.String(), "! 10:00:00.123 hello\n")
+
+	w.Write([]byte("ld"))
+	w.Flush()
+	require.Contains(t, logged.String(), "! 10:00:01.500 world\n")
+	require.Equal(t, "hello\nworld\n", out.String())
+}
@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/coldog/bld/pkg/builder"
+)
+
+// secretsEnvFile is sourced by the entrypoint to export secret environment
+// variables, they are never part of the container config and so are never
+// committed.
+const secretsEnvFile = "/.bld/secrets.sh"
+
+// secretsRoot is a memory backed directory on the host, secrets are only
+// written to disk if it does not exist.
+func secretsRoot() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}
+
+func (e *Executor) secretsDir(step builder.StepExec) string {
+	return secretsRoot() + "/bld-secrets/" + step.BuildID + "/" + step.Name
+}
+
+// writeSecrets writes the secrets of a step to the host and returns the binds
+// that mount them read only in the container. Bind mounts are not committed.
+func (e *Executor) writeSecrets(step builder.StepExec) ([]string, error) {
+	if len(step.SecretValues) == 0 {
+		return nil, nil
+	}
+	dir := e.secretsDir(step)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	binds := []string{}
+	env := "#!/bin/sh\n"
+	for i, secret := range step.Step.Secrets {
+		val, ok := step.SecretValues[secret.Name]
+		if !ok {
+			return nil, fmt.Errorf("executor: missing secret %s", secret.Name)
+		}
+		if secret.Env != "" {
+			env += "export " + secret.Env + "=" + quote(val) + "\n"
+		}
+		if secret.File != "" {
+			file := fmt.Sprintf("%s/%d_%s", dir, i, secret.Name)
+			if err := ioutil.WriteFile(file, []byte(val), 0444); err != nil {
+				return nil, err
+			}
+			binds = append(binds, file+":"+secret.File+":ro")
+		}
+	}
+	if err := ioutil.WriteFile(dir+"/secrets.sh", []byte(env), 0444); err != nil {
+		return nil, err
+	}
+	binds = append(binds, dir+"/secrets.sh:"+secretsEnvFile+":ro")
+	return binds, nil
+}
+
+func (e *Executor) removeSecrets(step builder.StepExec) error {
+	if len(step.SecretValues) == 0 {
+		return nil
+	}
+	return os.RemoveAll(e.secretsDir(step))
+}
+
+// quote single quotes a value for the shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,39 @@
+package executor
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSecrets(t *testing.T) {
+	e := &Executor{}
+	step := builder.StepExec{
+		Step: builder.Step{
+			Name: "secrets",
+			Secrets: []builder.SecretMount{
+				{Name: "token", Env: "TOKEN"},
+				{Name: "key", File: "/root/.ssh/id_rsa"},
+			},
+		},
+		BuildID:      "test",
+		SecretValues: map[string]string{"token": "it's secret", "key": "private"},
+	}
+
+	binds, err := e.writeSecrets(step)
+	require.NoError(t, err)
+	require.Len(t, binds, 2)
+	require.True(t, strings.HasSuffix(binds[0], ":/root/.ssh/id_rsa:ro"))
+	require.True(t, strings.HasSuffix(binds[1], ":"+secretsEnvFile+":ro"))
+
+	env, err := ioutil.ReadFile(e.secretsDir(step) + "/secrets.sh")
+	require.NoError(t, err)
+	require.Contains(t, string(env), `export TOKEN='it'\''s secret'`)
+
+	require.NoError(t, e.removeSecrets(step))
+	_, err = ioutil.ReadDir(e.secretsDir(step))
+	require.Error(t, err)
+}
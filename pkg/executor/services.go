@@ -0,0 +1,188 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/coldog/bld/pkg/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
+)
+
+const (
+	defaultHealthInterval = time.Second
+	defaultHealthRetries  = 30
+)
+
+// ResolveImage pulls an image if needed and returns its repo digest, or its ID
+// for images that were never pulled from a registry.
+func (e *Executor) ResolveImage(ctx context.Context, image string) (string, error) {
+	if err := e.pullImage(ctx, image); err != nil {
+		return "", err
+	}
+	inspect, _, err := e.client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if len(inspect.RepoDigests) > 0 {
+		return inspect.RepoDigests[0], nil
+	}
+	return inspect.ID, nil
+}
+
+func (e *Executor) networkName(step builder.StepExec) string {
+	return step.BuildID + "_" + step.Name
+}
+
+func healthConfig(h *builder.Healthcheck) (*container.HealthConfig, error) {
+	if h == nil {
+		return nil, nil
+	}
+	interval := defaultHealthInterval
+	if h.Interval != "" {
+		d, err := time.ParseDuration(h.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid healthcheck interval: %v", err)
+		}
+		interval = d
+	}
+	retries := h.Retries
+	if retries == 0 {
+		retries = defaultHealthRetries
+	}
+	return &container.HealthConfig{
+		Test:     append([]string{"CMD"}, h.Command...),
+		Interval: interval,
+		Timeout:  interval,
+		Retries:  retries,
+	}, nil
+}
+
+// StartServices creates a network for the step and starts its services on it,
+// each service is reachable by its name. It waits for every service to be
+// healthy, or running if it has no healthcheck. The returned function removes
+// the services and the network, it is safe to call after ctx is cancelled.
+func (e *Executor) startServices(
+	ctx context.Context, step builder.StepExec) (string, func(), error) {
+	logger := log.ContextGetLogger(ctx)
+
+	name := e.networkName(step)
+	net, err := e.client.NetworkCreate(ctx, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Labels:         e.labels(step),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	ids := []string{}
+	stop := func() {
+		for _, id := range ids {
+			logger.V(4).Printf("removing service id=%s", id)
+			if err := e.stop(id); err != nil {
+				logger.Printf("failed to remove service id=%s: %v", id, err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), defaultStopTimeout)
+		defer cancel()
+		if err := e.client.NetworkRemove(ctx, net.ID); err != nil {
+			logger.Printf("failed to remove network id=%s: %v", net.ID, err)
+		}
+	}
+
+	for _, svc := range step.Services {
+		logger.Printf("starting service %s (%s)", svc.Name, svc.Image)
+		id, err := e.startService(ctx, step, name, svc)
+		if id != "" {
+			ids = append(ids, id)
+		}
+		if err != nil {
+			stop()
+			return "", nil, fmt.Errorf("service %s: %v", svc.Name, err)
+		}
+	}
+	for i, svc := range step.Services {
+		if err := e.waitHealthy(ctx, ids[i], svc); err != nil {
+			stop()
+			return "", nil, fmt.Errorf("service %s: %v", svc.Name, err)
+		}
+		logger.V(2).Printf("service ready %s", svc.Name)
+	}
+	return name, stop, nil
+}
+
+func (e *Executor) startService(
+	ctx context.Context,
+	step builder.StepExec,
+	networkName string,
+	svc builder.Service,
+) (string, error) {
+	if err := e.pullImage(ctx, svc.Image); err != nil {
+		return "", err
+	}
+	health, err := healthConfig(svc.Healthcheck)
+	if err != nil {
+		return "", err
+	}
+	config := &container.Config{
+		Image:       svc.Image,
+		Env:         svc.Env,
+		Labels:      e.labels(step),
+		Healthcheck: health,
+	}
+	if len(svc.Command) > 0 {
+		config.Cmd = strslice.StrSlice(svc.Command)
+	}
+	hostConfig := &container.HostConfig{
+		NetworkMode: container.NetworkMode(networkName),
+	}
+	netConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			networkName: {Aliases: []string{svc.Name}},
+		},
+	}
+	ct, err := e.client.ContainerCreate(
+		ctx, config, hostConfig, netConfig, networkName+"_"+svc.Name)
+	if err != nil {
+		return "", err
+	}
+	return ct.ID, e.client.ContainerStart(ctx, ct.ID, types.ContainerStartOptions{})
+}
+
+func (e *Executor) waitHealthy(ctx context.Context, id string, svc builder.Service) error {
+	interval := defaultHealthInterval
+	if svc.Healthcheck != nil && svc.Healthcheck.Interval != "" {
+		if d, err := time.ParseDuration(svc.Healthcheck.Interval); err == nil {
+			interval = d
+		}
+	}
+	for {
+		inspect, err := e.client.ContainerInspect(ctx, id)
+		if err != nil {
+			return err
+		}
+		state := inspect.State
+		if !state.Running {
+			return fmt.Errorf("exited with code %d", state.ExitCode)
+		}
+		if state.Health == nil {
+			return nil
+		}
+		switch state.Health.Status {
+		case "healthy":
+			return nil
+		case "unhealthy":
+			return fmt.Errorf("unhealthy")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthConfig(t *testing.T) {
+	c, err := healthConfig(nil)
+	require.NoError(t, err)
+	require.Nil(t, c)
+
+	c, err = healthConfig(&builder.Healthcheck{
+		Command:  []string{"pg_isready"},
+		Interval: "2s",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"CMD", "pg_isready"}, c.Test)
+	require.Equal(t, 2*time.Second, c.Interval)
+	require.Equal(t, defaultHealthRetries, c.Retries)
+
+	_, err = healthConfig(&builder.Healthcheck{Interval: "soon"})
+	require.Error(t, err)
+}
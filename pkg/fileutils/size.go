@@ -0,0 +1,21 @@
+package fileutils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Size returns the total size in bytes of the regular files in a directory.
+func Size(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
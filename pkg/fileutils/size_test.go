@@ -0,0 +1,18 @@
+package fileutils
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSize(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	err := ioutil.WriteFile(dir+"/test.txt", []byte("hello"), Regular)
+	require.NoError(t, err)
+
+	size, err := Size(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), size)
+}
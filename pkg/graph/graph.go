@@ -12,6 +12,11 @@ import (
 // ErrFinished will be returned if the Solver is closed.
 var ErrFinished = errors.New("selector finished")
 
+// ErrSkipped is returned by Select alongside the selected id when one of its
+// dependencies has failed. The id is marked as failed so that everything
+// downstream of it is skipped as well.
+var ErrSkipped = errors.New("dependency failed")
+
 // Solver is a graph solver. It takes a given build and returns units of work to
 // goroutines. It is aware of the build graph and the pieces of the build
 // pipeline, specifically it is aware that sources control when builds are
@@ -42,7 +47,11 @@ func (s *Solver) Close() {
 
 // Done marks a unit of work as complete, any dependencies will now be
 // available.
-func (s *Solver) Done(id string) { s.complete.add(id) }
+func (s *Solver) Done(id string) { s.complete.add(id, true) }
+
+// Fail marks a unit of work as failed, any dependencies will be returned from
+// Select with ErrSkipped.
+func (s *Solver) Fail(id string) { s.complete.add(id, false) }
 
 // Select will select work that needs to be completed from the graph.
 func (s *Solver) Select(ctx context.Context) (string, error) {
@@ -70,7 +79,12 @@ func (s *Solver) Select(ctx context.Context) (string, error) {
 		return "", ctx.Err()
 	}
 	for dep := range s.dependencies[id] {
-		if err := s.complete.wait(ctx, dep); err != nil {
+		err := s.complete.wait(ctx, dep)
+		if err == ErrSkipped {
+			s.Fail(id)
+			return id, ErrSkipped
+		}
+		if err != nil {
 			return "", err
 		}
 	}
@@ -101,8 +115,8 @@ func (s *Solver) Solve() {
 
 	// Mapping from step name to the next step.
 	for _, s := range s.Build.Steps {
-		for _, src := range s.Imports {
-			adj := sourceToStep[src.Source]
+		for _, src := range s.Sources() {
+			adj := sourceToStep[src]
 			adjacency[adj].add(s.Name)
 		}
 	}
@@ -170,22 +184,24 @@ func (s set) list() (l []string) {
 func newWatchSet() *watchSet {
 	return &watchSet{
 		m:    map[string]bool{},
-		subs: map[string]map[chan string]struct{}{},
+		subs: map[string]map[chan bool]struct{}{},
 	}
 }
 
+// watchSet tracks completed items, the value stored for an item is false if
+// the item failed.
 type watchSet struct {
 	sync.Mutex
 	m    map[string]bool
-	subs map[string]map[chan string]struct{}
+	subs map[string]map[chan bool]struct{}
 }
 
-func (s *watchSet) add(item string) {
+func (s *watchSet) add(item string, ok bool) {
 	s.Lock()
-	s.m[item] = true
+	s.m[item] = ok
 	if s.subs[item] != nil {
 		for c := range s.subs[item] {
-			c <- item
+			c <- ok
 		}
 	}
 	s.Unlock()
@@ -193,26 +209,32 @@ func (s *watchSet) add(item string) {
 
 func (s *watchSet) wait(ctx context.Context, item string) error {
 	s.Lock()
-	status := s.m[item]
-	s.Unlock()
-	if status {
+	if ok, status := s.m[item]; status {
+		s.Unlock()
+		if !ok {
+			return ErrSkipped
+		}
 		return nil
 	}
 
-	c := make(chan string)
-
-	s.Lock()
+	c := make(chan bool, 1)
 	if s.subs[item] == nil {
-		s.subs[item] = map[chan string]struct{}{}
+		s.subs[item] = map[chan bool]struct{}{}
 	}
 	s.subs[item][c] = struct{}{}
 	s.Unlock()
 
-	select {
-	case <-c:
+	defer func() {
 		s.Lock()
 		delete(s.subs[item], c)
 		s.Unlock()
+	}()
+
+	select {
+	case ok, open := <-c:
+		if open && !ok {
+			return ErrSkipped
+		}
 		return nil
 	case <-ctx.Done():
 		return ctx.Err()
@@ -220,10 +242,13 @@ func (s *watchSet) wait(ctx context.Context, item string) error {
 }
 
 func (s *watchSet) close() {
-	s.m = nil
+	s.Lock()
+	defer s.Unlock()
+	s.m = map[string]bool{}
 	for _, subs := range s.subs {
 		for sub := range subs {
 			close(sub)
 		}
 	}
+	s.subs = map[string]map[chan bool]struct{}{}
 }
@@ -99,3 +99,58 @@ func TestSolver_Simple(t *testing.T) {
 	})
 	require.ElementsMatch(t, []string{"source/r1", "s1-1", "s1-2", "s1-3"}, out)
 }
+
+func TestSolver_Fail(t *testing.T) {
+	s := &Solver{
+		Build: builder.Build{
+			Name: "test",
+			Sources: []builder.Source{
+				{Name: "r1", Target: "/tmp"},
+			},
+			Steps: []builder.Step{
+				{
+					Name:    "s1",
+					Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+					Exports: []builder.Mount{{Source: "r2", Mount: "/usr/src/app2"}},
+				},
+				{
+					Name:    "s2",
+					Imports: []builder.Mount{{Source: "r2", Mount: "/usr/src/app"}},
+					Exports: []builder.Mount{{Source: "r3", Mount: "/usr/src/app3"}},
+				},
+				{
+					Name:    "s3",
+					Imports: []builder.Mount{{Source: "r3", Mount: "/usr/src/app"}},
+				},
+				{
+					Name:    "s4",
+					Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+				},
+			},
+		},
+	}
+	s.Solve()
+	defer s.Close()
+
+	done := []string{}
+	skipped := []string{}
+	for {
+		id, err := s.Select(context.Background())
+		if err == ErrFinished {
+			break
+		}
+		if err == ErrSkipped {
+			skipped = append(skipped, id)
+			continue
+		}
+		require.NoError(t, err)
+		if id == "s1" {
+			s.Fail(id)
+			continue
+		}
+		done = append(done, id)
+		s.Done(id)
+	}
+	require.ElementsMatch(t, []string{"source/r1", "s4"}, done)
+	require.ElementsMatch(t, []string{"s2", "s3"}, skipped)
+}
@@ -0,0 +1,45 @@
+package log
+
+import "time"
+
+// EventType is the type of a build lifecycle event.
+type EventType string
+
+// Lifecycle events.
+const (
+	EventBuildStarted  EventType = "build_started"
+	EventBuildFinished EventType = "build_finished"
+	EventStepQueued    EventType = "step_queued"
+	EventStepStarted   EventType = "step_started"
+	EventStepCached    EventType = "step_cached"
+	EventStepFinished  EventType = "step_finished"
+	EventStepFailed    EventType = "step_failed"
+)
+
+// Event is a typed lifecycle event, events are only written in the JSON
+// format.
+type Event struct {
+	Type     EventType
+	Digest   string
+	Duration time.Duration
+	Err      error
+}
+
+// Event writes a lifecycle event along with the fields of the logger.
+func (l Logger) Event(e Event) {
+	if !isJSON() {
+		return
+	}
+	entry := l.entry(time.Now().UTC())
+	entry["event"] = e.Type
+	if e.Digest != "" {
+		entry["digest"] = e.Digest
+	}
+	if e.Duration != 0 {
+		entry["duration"] = e.Duration.Seconds()
+	}
+	if e.Err != nil {
+		entry["error"] = e.Err.Error()
+	}
+	l.writeJSON(entry)
+}
@@ -2,6 +2,7 @@ package log
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -11,14 +12,43 @@ import (
 
 type contextKey int
 
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
 var (
-	key   contextKey
-	level uint32
+	key    contextKey
+	level  uint32
+	format atomic.Value
+	output atomic.Value
 )
 
 // Level will store a new global log level for the package.
 func Level(l uint32) { atomic.StoreUint32(&level, l) }
 
+// Format will store a new global output format for the package.
+func Format(f string) error {
+	if f != FormatText && f != FormatJSON {
+		return fmt.Errorf("log: invalid format %q", f)
+	}
+	format.Store(f)
+	return nil
+}
+
+// DefaultOutput will store a new global output for loggers without an output.
+func DefaultOutput(w io.Writer) { output.Store(outputWriter{w}) }
+
+// outputWriter wraps the writer stored in output, atomic.Value requires a
+// consistent concrete type.
+type outputWriter struct{ io.Writer }
+
+func isJSON() bool {
+	f, _ := format.Load().(string)
+	return f == FormatJSON
+}
+
 // ContextGetLogger will return a logger stored in a context key.
 func ContextGetLogger(ctx context.Context) Logger {
 	if v, ok := ctx.Value(key).(Logger); ok {
@@ -37,51 +67,93 @@ type Logger struct {
 	level  uint32
 	prefix string
 	output io.Writer
+	fields map[string]interface{}
 }
 
 // Output returns a new logger with a changed output.
 func (l Logger) Output(out io.Writer) Logger {
-	return Logger{
-		level:  l.level,
-		prefix: l.prefix,
-		output: out,
-	}
+	l.output = out
+	return l
 }
 
 // V will return a new Logger that will only log if the current level is greater
 // than or equal to the provided level.
 func (l Logger) V(level uint32) Logger {
-	return Logger{
-		level:  level,
-		prefix: l.prefix,
-		output: l.output,
-	}
+	l.level = level
+	return l
 }
 
 // Prefix sets a new prefix and returns a new Logger.
 func (l Logger) Prefix(prefix string) Logger {
-	return Logger{
-		level:  l.level,
-		prefix: "[" + prefix + "] ",
-		output: l.output,
+	l.prefix = "[" + prefix + "] "
+	return l
+}
+
+// With returns a new Logger with a field that is added to JSON output, fields
+// are not printed in the text format.
+func (l Logger) With(key string, val interface{}) Logger {
+	fields := map[string]interface{}{}
+	for k, v := range l.fields {
+		fields[k] = v
 	}
+	fields[key] = val
+	l.fields = fields
+	return l
 }
 
 // Print will print a new message.
-func (l Logger) Print(msg string) { l.write(msg) }
+func (l Logger) Print(msg string) { l.write("%s", msg) }
 
 // Printf will print and format.
 func (l Logger) Printf(msg string, args ...interface{}) { l.write(msg, args...) }
 
-func (l Logger) write(s string, args ...interface{}) {
-	output := l.output
-	if output == nil {
-		output = os.Stdout
+func (l Logger) out() io.Writer {
+	if l.output != nil {
+		return l.output
+	}
+	if w, ok := output.Load().(outputWriter); ok {
+		return w.Writer
 	}
+	return os.Stdout
+}
+
+func (l Logger) write(s string, args ...interface{}) {
 	current := atomic.LoadUint32(&level)
-	if l.level <= current {
-		t := time.Now().UTC()
-		msg := "[" + t.Format(time.RFC3339) + "] " + l.prefix + s + "\n"
-		fmt.Fprintf(output, msg, args...)
+	if l.level > current {
+		return
+	}
+	t := time.Now().UTC()
+	msg := Redact(fmt.Sprintf(s, args...))
+	if isJSON() {
+		entry := l.entry(t)
+		entry["level"] = l.level
+		entry["msg"] = msg
+		l.writeJSON(entry)
+		return
+	}
+	io.WriteString(l.out(), "["+t.Format(time.RFC3339)+"] "+l.prefix+msg+"\n")
+}
+
+func (l Logger) entry(t time.Time) map[string]interface{} {
+	entry := map[string]interface{}{}
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["time"] = t.Format(time.RFC3339Nano)
+	return entry
+}
+
+// writeJSON redacts the string values of an entry before encoding it, once
+// encoded a secret may no longer match its escaped form.
+func (l Logger) writeJSON(entry map[string]interface{}) {
+	for k, v := range entry {
+		if s, ok := v.(string); ok {
+			entry[k] = Redact(s)
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
 	}
+	l.out().Write(append(data, '\n'))
 }
@@ -1,9 +1,15 @@
 package log
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestLog(t *testing.T) {
@@ -20,3 +26,67 @@ func TestLogContext(t *testing.T) {
 	ContextGetLogger(ctx).Print("hi")
 	ContextGetLogger(context.Background()).Print("hi")
 }
+
+func TestLogJSON(t *testing.T) {
+	Level(1)
+	require.NoError(t, Format(FormatJSON))
+	defer Format(FormatText)
+
+	buf := bytes.NewBuffer(nil)
+	l := Logger{}.Output(buf).With("build", "1").With("step", "test")
+	l.Printf("hello %s", "world")
+	l.Event(Event{Type: EventStepFinished, Digest: "abc", Duration: time.Second})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "hello world", entry["msg"])
+	require.Equal(t, "1", entry["build"])
+	require.Equal(t, "test", entry["step"])
+
+	entry = map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Equal(t, string(EventStepFinished), entry["event"])
+	require.Equal(t, "abc", entry["digest"])
+	require.Equal(t, 1.0, entry["duration"])
+
+	require.Error(t, Format("xml"))
+}
+
+func TestLogRedact(t *testing.T) {
+	Level(1)
+	AddSecret("s3cr3t", "")
+
+	buf := bytes.NewBuffer(nil)
+	l := Logger{}.Output(buf)
+	l.Printf("token=%s", "s3cr3t")
+	l.Print("100% done")
+	require.NotContains(t, buf.String(), "s3cr3t")
+	require.Contains(t, buf.String(), "token="+redacted)
+	require.Contains(t, buf.String(), "100% done")
+}
+
+func TestLogRedactLines(t *testing.T) {
+	Level(1)
+	AddSecret("file-s3cr3t\n", "line-one\nline-two\n", `quo"ted`)
+
+	buf := bytes.NewBuffer(nil)
+	l := Logger{}.Output(buf)
+	l.Print("file-s3cr3t")
+	l.Print("line-two")
+	require.NotContains(t, buf.String(), "s3cr3t")
+	require.NotContains(t, buf.String(), "line-two")
+
+	require.NoError(t, Format(FormatJSON))
+	defer Format(FormatText)
+	buf.Reset()
+	l.With("token", `quo"ted`).Print(`quo"ted`)
+	require.NotContains(t, buf.String(), "quo")
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, redacted, entry["msg"])
+	require.Equal(t, redacted, entry["token"])
+}
@@ -0,0 +1,53 @@
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "******"
+
+var (
+	secretsLock sync.RWMutex
+	secrets     []string
+)
+
+// AddSecret registers values that are replaced in all log output. Output is
+// redacted line by line, so the trimmed value and each of its lines are
+// registered as well.
+func AddSecret(values ...string) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for _, v := range values {
+		add(v)
+		add(strings.TrimSpace(v))
+		for _, line := range strings.Split(v, "\n") {
+			add(strings.TrimSpace(line))
+		}
+	}
+}
+
+func add(v string) {
+	if v == "" {
+		return
+	}
+	for _, s := range secrets {
+		if s == v {
+			return
+		}
+	}
+	secrets = append(secrets, v)
+	// Longer values go first so a value containing another is fully replaced.
+	sort.SliceStable(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+}
+
+// Redact replaces every registered secret in s.
+func Redact(s string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	for _, v := range secrets {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	return s
+}
@@ -0,0 +1,253 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coldog/bld/pkg/log"
+)
+
+const (
+	refreshInterval = 100 * time.Millisecond
+	tailLines       = 3
+	defaultWidth    = 80
+
+	// keepLines is the number of lines kept per step, they are printed when
+	// the step fails.
+	keepLines = 100
+)
+
+var spinner = []string{"|", "/", "-", "\\"}
+
+// IsTerminal returns true if the file is a character device.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// New returns a renderer writing to out. The renderer consumes the JSON log
+// format, it should be set as the log output with the JSON format enabled.
+func New(out io.Writer) *Renderer {
+	r := &Renderer{
+		out:   out,
+		width: terminalWidth(),
+		steps: map[string]*step{},
+		done:  make(chan struct{}),
+	}
+	go r.refresh()
+	return r
+}
+
+// Renderer shows each running step with a spinner, its elapsed time and the
+// last few lines of its output. Finished steps are collapsed into a single
+// line and the full output of failed steps is printed on Close.
+type Renderer struct {
+	out   io.Writer
+	width int
+
+	lock    sync.Mutex
+	steps   map[string]*step
+	running []string
+	failed  []string
+	drawn   int
+	frame   int
+	closed  bool
+	done    chan struct{}
+}
+
+type step struct {
+	name  string
+	start time.Time
+	lines []string
+	// dropped counts the lines that no longer fit in lines.
+	dropped int
+}
+
+// add keeps the last keepLines lines of output.
+func (s *step) add(line string) {
+	if len(s.lines) == keepLines {
+		copy(s.lines, s.lines[1:])
+		s.lines = s.lines[:keepLines-1]
+		s.dropped++
+	}
+	s.lines = append(s.lines, line)
+}
+
+type entry struct {
+	Event    log.EventType `json:"event"`
+	Step     string        `json:"step"`
+	Msg      string        `json:"msg"`
+	Duration float64       `json:"duration"`
+	Error    string        `json:"error"`
+}
+
+// Write consumes a single JSON log entry.
+func (r *Renderer) Write(b []byte) (int, error) {
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return 0, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return len(b), nil
+	}
+	r.clear()
+	defer r.draw()
+
+	switch e.Event {
+	case "":
+		if e.Step == "" {
+			fmt.Fprintln(r.out, e.Msg)
+			return len(b), nil
+		}
+		r.step(e.Step).add(e.Msg)
+	case log.EventStepStarted:
+		s := r.step(e.Step)
+		s.start = time.Now()
+		r.running = append(r.running, e.Step)
+	case log.EventStepCached:
+		r.finish(e, "cached")
+	case log.EventStepFinished:
+		r.finish(e, "ran")
+	case log.EventStepFailed:
+		r.finish(e, "failed")
+		r.failed = append(r.failed, e.Step)
+	case log.EventBuildFinished:
+		if e.Error != "" {
+			fmt.Fprintf(r.out, "build failed (%.1fs): %s\n", e.Duration, e.Error)
+		} else {
+			fmt.Fprintf(r.out, "build finished (%.1fs)\n", e.Duration)
+		}
+	}
+	return len(b), nil
+}
+
+// Close stops rendering and prints the full output of failed steps.
+func (r *Renderer) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	r.clear()
+	for _, name := range r.failed {
+		s := r.steps[name]
+		fmt.Fprintf(r.out, "--- %s output ---\n", name)
+		if s.dropped > 0 {
+			fmt.Fprintf(r.out, "... %d earlier lines, see bld logs %s\n", s.dropped, name)
+		}
+		for _, line := range s.lines {
+			fmt.Fprintln(r.out, line)
+		}
+	}
+	return nil
+}
+
+func (r *Renderer) step(name string) *step {
+	s, ok := r.steps[name]
+	if !ok {
+		s = &step{name: name, start: time.Now()}
+		r.steps[name] = s
+	}
+	return s
+}
+
+func (r *Renderer) finish(e entry, status string) {
+	r.step(e.Step)
+	for i, name := range r.running {
+		if name == e.Step {
+			r.running = append(r.running[:i], r.running[i+1:]...)
+			break
+		}
+	}
+	mark := "+"
+	if status == "failed" {
+		mark = "x"
+	}
+	fmt.Fprintf(r.out, "%s %s (%s, %.1fs)\n", mark, e.Step, status, e.Duration)
+}
+
+func (r *Renderer) refresh() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.lock.Lock()
+			r.frame++
+			r.clear()
+			r.draw()
+			r.lock.Unlock()
+		}
+	}
+}
+
+// Clear moves the cursor back over the live area and erases it.
+func (r *Renderer) clear() {
+	if r.drawn > 0 {
+		fmt.Fprintf(r.out, "\033[%dA\033[J", r.drawn)
+	}
+	r.drawn = 0
+}
+
+// Draw prints the live area for all running steps.
+func (r *Renderer) draw() {
+	for _, name := range r.running {
+		s := r.steps[name]
+		r.line(fmt.Sprintf(
+			"%s %s (%.1fs)",
+			spinner[r.frame%len(spinner)], name, time.Since(s.start).Seconds(),
+		))
+		tail := s.lines
+		if len(tail) > tailLines {
+			tail = tail[len(tail)-tailLines:]
+		}
+		for _, l := range tail {
+			r.line("    " + l)
+		}
+	}
+}
+
+// truncate shortens s to n runes so multi-byte characters are never split.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+func (r *Renderer) line(s string) {
+	s = strings.Replace(s, "\t", "    ", -1)
+	fmt.Fprintln(r.out, truncate(s, r.width-1))
+	r.drawn++
+}
+
+func terminalWidth() int {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return defaultWidth
+	}
+	var height, width int
+	if _, err := fmt.Sscanf(string(out), "%d %d", &height, &width); err != nil || width <= 0 {
+		return defaultWidth
+	}
+	return width
+}
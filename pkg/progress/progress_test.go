@@ -0,0 +1,53 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderer(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	r := New(buf)
+
+	for _, line := range []string{
+		`{"msg":"starting build"}`,
+		`{"event":"step_started","step":"s1"}`,
+		`{"event":"step_started","step":"s2"}`,
+		`{"step":"s1","msg":"> hello"}`,
+		`{"step":"s2","msg":"! broken"}`,
+		`{"event":"step_finished","step":"s1","duration":1.5}`,
+		`{"event":"step_cached","step":"s3","duration":0.1}`,
+		`{"event":"step_failed","step":"s2","duration":2,"error":"exit code 1"}`,
+	} {
+		_, err := r.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, r.Close())
+
+	out := buf.String()
+	require.Contains(t, out, "starting build\n")
+	require.Contains(t, out, "+ s1 (ran, 1.5s)\n")
+	require.Contains(t, out, "+ s3 (cached, 0.1s)\n")
+	require.Contains(t, out, "x s2 (failed, 2.0s)\n")
+	require.Contains(t, out, "--- s2 output ---\n! broken\n")
+}
+
+func TestRendererKeepLines(t *testing.T) {
+	s := &step{name: "s1"}
+	for i := 0; i < keepLines+5; i++ {
+		s.add(fmt.Sprint(i))
+	}
+	require.Len(t, s.lines, keepLines)
+	require.Equal(t, 5, s.dropped)
+	require.Equal(t, "5", s.lines[0])
+	require.Equal(t, fmt.Sprint(keepLines+4), s.lines[keepLines-1])
+}
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "héllo", truncate("héllo", 5))
+	require.Equal(t, "hé", truncate("héllo", 2))
+	require.Equal(t, "✓✓", truncate("✓✓✓", 2))
+}
@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/moby/moby/client"
+)
+
+// defaultHost is the key used for Docker Hub in the docker config file.
+const defaultHost = "https://index.docker.io/v1/"
+
+// Pusher pushes images to a registry.
+type Pusher interface {
+	// Push tags image as tag and pushes the tag, it returns the pushed digest.
+	Push(ctx context.Context, image, tag string) (string, error)
+}
+
+// DefaultConfigFile returns the path of the docker client config file.
+func DefaultConfigFile() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir + "/config.json"
+	}
+	home, _ := os.UserHomeDir()
+	return home + "/.docker/config.json"
+}
+
+// Host returns the registry host for an image reference, images without a
+// host are pushed to Docker Hub.
+func Host(ref string) string {
+	i := strings.IndexByte(ref, '/')
+	if i < 0 {
+		return defaultHost
+	}
+	host := ref[:i]
+	if (!strings.ContainsAny(host, ".:") && host != "localhost") || dockerHub(host) {
+		return defaultHost
+	}
+	return host
+}
+
+// dockerHub returns true for the hostnames of Docker Hub.
+func dockerHub(host string) bool {
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return true
+	}
+	return false
+}
+
+// authHost returns the registry host of a key in the auths of the docker
+// config, keys may be URLs.
+func authHost(key string) string {
+	if key == defaultHost {
+		return key
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	if dockerHub(host) {
+		return defaultHost
+	}
+	return host
+}
+
+type config struct {
+	Auths       map[string]types.AuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// helper returns the credential helper configured for host, helpers listed
+// for the host in credHelpers take precedence over credsStore.
+func (c config) helper(host string) string {
+	for key, h := range c.CredHelpers {
+		if authHost(key) == host {
+			return h
+		}
+	}
+	return c.CredsStore
+}
+
+// helperCredentials is the output of `docker-credential-<helper> get`.
+type helperCredentials struct {
+	Username string `json:"Username"`
+	Secret   string `json:"Secret"`
+}
+
+// runHelper runs a docker credential helper for a registry host, it is
+// replaced in tests.
+var runHelper = func(helper, host string) (helperCredentials, error) {
+	var creds helperCredentials
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	out, err := cmd.Output()
+	if err != nil {
+		return creds, fmt.Errorf("credential helper %s: %v", helper, err)
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return creds, fmt.Errorf("credential helper %s: %v", helper, err)
+	}
+	return creds, nil
+}
+
+// Auth returns the encoded credentials for the registry of ref from the docker
+// config file, credential helpers configured with credsStore or credHelpers
+// are used the same way as the docker client. An empty auth is returned if no
+// credentials are configured.
+func Auth(configFile, ref string) (string, error) {
+	var auth types.AuthConfig
+	host := Host(ref)
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err == nil {
+		var c config
+		if err := json.Unmarshal(data, &c); err != nil {
+			return "", fmt.Errorf("registry: invalid docker config: %v", err)
+		}
+		if helper := c.helper(host); helper != "" {
+			creds, err := runHelper(helper, host)
+			if err != nil {
+				return "", fmt.Errorf("registry: %v", err)
+			}
+			// Helpers return identity tokens with the username "<token>".
+			if creds.Username == "<token>" {
+				auth.IdentityToken = creds.Secret
+			} else {
+				auth.Username, auth.Password = creds.Username, creds.Secret
+			}
+		} else {
+			for key, a := range c.Auths {
+				if authHost(key) == host {
+					auth = a
+					break
+				}
+			}
+		}
+	}
+
+	// The config stores credentials as base64 "user:password".
+	if auth.Auth != "" && auth.Username == "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", fmt.Errorf("registry: invalid auth for %s: %v", Host(ref), err)
+		}
+		spl := strings.SplitN(string(decoded), ":", 2)
+		if len(spl) == 2 {
+			auth.Username, auth.Password = spl[0], spl[1]
+		}
+		auth.Auth = ""
+	}
+	auth.ServerAddress = Host(ref)
+
+	data, err = json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// NewDockerPusher returns a Pusher using the docker daemon, credentials are
+// read from configFile.
+func NewDockerPusher(configFile string) (Pusher, error) {
+	client, err := client.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+	return &dockerPusher{client: client, configFile: configFile}, nil
+}
+
+// imageClient is the part of the docker client used to push images.
+type imageClient interface {
+	ImageTag(ctx context.Context, image, ref string) error
+	ImagePush(ctx context.Context, ref string, options types.ImagePushOptions) (io.ReadCloser, error)
+}
+
+type dockerPusher struct {
+	client     imageClient
+	configFile string
+}
+
+type pushMessage struct {
+	Error string `json:"error"`
+	Aux   struct {
+		Tag    string `json:"Tag"`
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+func (p *dockerPusher) Push(ctx context.Context, image, tag string) (string, error) {
+	if err := p.client.ImageTag(ctx, image, tag); err != nil {
+		return "", err
+	}
+	auth, err := Auth(p.configFile, tag)
+	if err != nil {
+		return "", err
+	}
+	r, err := p.client.ImagePush(ctx, tag, types.ImagePushOptions{
+		RegistryAuth: auth,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	var digest string
+	dec := json.NewDecoder(r)
+	for {
+		var msg pushMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if msg.Error != "" {
+			return "", errors.New(msg.Error)
+		}
+		if msg.Aux.Digest != "" {
+			digest = msg.Aux.Digest
+		}
+	}
+	return digest, nil
+}
@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAuth(t *testing.T, auth string) types.AuthConfig {
+	data, err := base64.URLEncoding.DecodeString(auth)
+	require.NoError(t, err)
+	var c types.AuthConfig
+	require.NoError(t, json.Unmarshal(data, &c))
+	return c
+}
+
+func TestHost(t *testing.T) {
+	require.Equal(t, defaultHost, Host("alpine"))
+	require.Equal(t, defaultHost, Host("coldog/bld:latest"))
+	require.Equal(t, "gcr.io", Host("gcr.io/project/bld"))
+	require.Equal(t, "localhost:5000", Host("localhost:5000/bld"))
+	require.Equal(t, defaultHost, Host("docker.io/coldog/bld"))
+	require.Equal(t, defaultHost, Host("index.docker.io/coldog/bld"))
+}
+
+func TestAuth(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"},
+		"https://gcr.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("_json_key:secret")) + `"}
+	}}`)
+	require.NoError(t, err)
+	f.Close()
+
+	decode := func(auth string) types.AuthConfig { return decodeAuth(t, auth) }
+
+	auth, err := Auth(f.Name(), "coldog/bld")
+	require.NoError(t, err)
+	c := decode(auth)
+	require.Equal(t, "user", c.Username)
+	require.Equal(t, "pass", c.Password)
+
+	auth, err = Auth(f.Name(), "gcr.io/project/bld")
+	require.NoError(t, err)
+	c = decode(auth)
+	require.Equal(t, "_json_key", c.Username)
+	require.Equal(t, "gcr.io", c.ServerAddress)
+
+	auth, err = Auth(f.Name(), "quay.io/bld")
+	require.NoError(t, err)
+	require.Equal(t, "", decode(auth).Username)
+
+	auth, err = Auth(f.Name(), "docker.io/coldog/bld")
+	require.NoError(t, err)
+	require.Equal(t, "user", decode(auth).Username)
+
+	_, err = Auth("/does/not/exist", "coldog/bld")
+	require.NoError(t, err)
+}
+
+func TestAuthHelpers(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{
+		"auths": {"https://index.docker.io/v1/": {}},
+		"credsStore": "desktop",
+		"credHelpers": {"gcr.io": "gcloud"}
+	}`)
+	require.NoError(t, err)
+	f.Close()
+
+	calls := map[string]string{}
+	defer func(fn func(string, string) (helperCredentials, error)) { runHelper = fn }(runHelper)
+	runHelper = func(helper, host string) (helperCredentials, error) {
+		calls[helper] = host
+		if helper == "gcloud" {
+			return helperCredentials{Username: "<token>", Secret: "token"}, nil
+		}
+		return helperCredentials{Username: "user", Secret: "pass"}, nil
+	}
+
+	auth, err := Auth(f.Name(), "docker.io/coldog/bld")
+	require.NoError(t, err)
+	c := decodeAuth(t, auth)
+	require.Equal(t, "user", c.Username)
+	require.Equal(t, "pass", c.Password)
+	require.Equal(t, defaultHost, calls["desktop"])
+
+	auth, err = Auth(f.Name(), "gcr.io/project/bld")
+	require.NoError(t, err)
+	c = decodeAuth(t, auth)
+	require.Equal(t, "token", c.IdentityToken)
+	require.Equal(t, "gcr.io", calls["gcloud"])
+}
+
+type fakeClient struct {
+	tags   map[string]string
+	stream string
+}
+
+func (c *fakeClient) ImageTag(ctx context.Context, image, ref string) error {
+	c.tags[ref] = image
+	return nil
+}
+
+func (c *fakeClient) ImagePush(
+	ctx context.Context, ref string, options types.ImagePushOptions) (io.ReadCloser, error) {
+	if options.RegistryAuth == "" {
+		return nil, errors.New("missing auth")
+	}
+	return ioutil.NopCloser(strings.NewReader(c.stream)), nil
+}
+
+func TestDockerPusher(t *testing.T) {
+	client := &fakeClient{
+		tags: map[string]string{},
+		stream: `{"status":"Pushed"}
+{"progressDetail":{},"aux":{"Tag":"1","Digest":"sha256:abc","Size":100}}
+`,
+	}
+	p := &dockerPusher{client: client, configFile: "/does/not/exist"}
+	digest, err := p.Push(context.Background(), "step:digest", "gcr.io/project/step:1")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:abc", digest)
+	require.Equal(t, "step:digest", client.tags["gcr.io/project/step:1"])
+
+	client.stream = `{"error":"denied: requested access to the resource is denied"}`
+	_, err = p.Push(context.Background(), "step:digest", "gcr.io/project/step:1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "denied")
+}
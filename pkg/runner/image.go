@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/coldog/bld/pkg/fileutils"
+	"github.com/coldog/bld/pkg/store"
+)
+
+// ImageDigest returns the digest of the image committed by a step. If buildID
+// is empty the image from the latest build is returned.
+func ImageDigest(s store.Store, buildID, step string) (string, error) {
+	if buildID == "" {
+		id, err := s.GetKey(latestBuildKey)
+		if err != nil {
+			return "", fmt.Errorf("no builds found: %v", err)
+		}
+		buildID = id
+	}
+	digest, err := s.GetKey("images/" + buildID + "/" + step)
+	if err != nil {
+		return "", fmt.Errorf("no image for %s in build %s: %v", step, buildID, err)
+	}
+	return digest, nil
+}
+
+// ExportImage writes the image committed by a step as an OCI image layout tar
+// archive to file.
+func ExportImage(ctx context.Context, is store.ImageStore, name, digest, file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), fileutils.Directory); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := is.Export(ctx, name, digest, f); err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	return f.Close()
+}
+
+// LinkImage records the image committed by a step in the current build.
+func (r *Runner) linkImage(name, digest string) error {
+	return r.Store.PutKey("images/"+r.Build.ID+"/"+name, digest)
+}
+
+// WriteImages exports the image of every step with an OCI path set.
+func (r *Runner) writeImages(ctx context.Context) error {
+	for _, step := range r.Build.Steps {
+		if step.Build == nil || step.Build.OCI == "" {
+			continue
+		}
+		r.lock.RLock()
+		digest, ok := r.steps[step.Name]
+		r.lock.RUnlock()
+		if !ok {
+			return fmt.Errorf("step %s was not built", step.Name)
+		}
+		file := step.Build.OCI
+		if !path.IsAbs(file) {
+			file = r.dir(step.Build.Dir, file)
+		}
+		r.logger.V(2).Printf("exporting image step=%s file=%s", step.Name, file)
+		if err := ExportImage(ctx, r.ImageStore, step.Name, digest, file); err != nil {
+			return fmt.Errorf("failed to export image %s: %v", step.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/coldog/bld/pkg/fileutils"
+)
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// CollectReports copies the files matched by the reports of a step from its
+// exports into ReportsDir/<step>/.
+func (r *Runner) collectReports(step builder.Step) error {
+	if r.ReportsDir == "" {
+		return nil
+	}
+	for _, report := range step.Reports {
+		if !step.Exported(report.Source) {
+			return fmt.Errorf("report source %s is not exported", report.Source)
+		}
+		dir := r.getSrcDir(report.Source)
+		matches, err := filepath.Glob(filepath.Join(dir, report.Path))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		files := []string{}
+		for _, match := range matches {
+			file, err := filepath.Rel(dir, match)
+			if err != nil {
+				return err
+			}
+			files = append(files, file)
+		}
+		dest := filepath.Join(r.ReportsDir, step.Name)
+		r.logger.V(3).Printf("collecting reports step=%s files=%v", step.Name, files)
+		if err := fileutils.Copy(dir, dest, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJUnit writes a JUnit file for the last run where each step is a test
+// case with its duration, failure message and captured output.
+func (r *Runner) WriteJUnit(file string) error {
+	report := r.Report()
+	suite := junitSuite{
+		Name: report.Name,
+		Time: report.Duration,
+	}
+	for _, n := range report.Nodes {
+		if n.Type != NodeStep {
+			continue
+		}
+		c := junitCase{
+			Name:      n.Name,
+			Classname: report.Name,
+			Time:      n.Duration,
+		}
+		if rc, err := Logs(r.Store, report.ID, n.Name); err == nil {
+			data, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			c.SystemOut = string(data)
+		}
+		switch n.Status {
+		case StatusFailed:
+			suite.Failures++
+			c.Failure = &junitFailure{Message: n.Error, Body: c.SystemOut}
+		case StatusSkipped:
+			suite.Skipped++
+			c.Skipped = &struct{}{}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), fileutils.Directory); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append([]byte(xml.Header), data...), fileutils.Regular)
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/coldog/bld/pkg/content"
+	"github.com/coldog/bld/pkg/fileutils"
+	"github.com/coldog/bld/pkg/log"
+	"github.com/coldog/bld/pkg/store"
+)
+
+// latestBuildKey points to the ID of the last build that was started.
+const latestBuildKey = "build/latest"
+
+// Logs returns the saved output of a step. If buildID is empty the output from
+// the latest build is returned.
+func Logs(s store.Store, buildID, step string) (io.ReadCloser, error) {
+	if buildID == "" {
+		id, err := s.GetKey(latestBuildKey)
+		if err != nil {
+			return nil, fmt.Errorf("no builds found: %v", err)
+		}
+		buildID = id
+	}
+	key, err := s.GetKey("logs/" + buildID + "/" + step)
+	if err != nil {
+		return nil, fmt.Errorf("no logs for %s in build %s: %v", step, buildID, err)
+	}
+	return s.LoadStream(key)
+}
+
+func (r *Runner) logFile(name string) string {
+	return r.BuildDir + "/logs/" + r.Build.ID + "/" + name + ".log"
+}
+
+// CreateLog opens the file that captures the output of a step while it runs.
+func (r *Runner) createLog(name string) (*os.File, error) {
+	file := r.logFile(name)
+	if err := os.MkdirAll(filepath.Dir(file), fileutils.Directory); err != nil {
+		return nil, err
+	}
+	return os.Create(file)
+}
+
+// SaveLog stores the captured output of a step as a content blob. It is linked
+// to the step digest, for replaying cached steps, and to the build.
+func (r *Runner) saveLog(name, digest string) error {
+	file := r.logFile(name)
+	logDigest, err := content.DigestFiles(filepath.Dir(file), []string{filepath.Base(file)})
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	if err := r.Store.SaveStream(logDigest, f); err != nil {
+		return err
+	}
+	if err := r.Store.PutKey("log/"+digest, logDigest); err != nil {
+		return err
+	}
+	return r.Store.PutKey("logs/"+r.Build.ID+"/"+name, logDigest)
+}
+
+// ReplayLog prints the saved output of a cached step and links it to the
+// current build.
+func (r *Runner) replayLog(logger log.Logger, name, digest string) error {
+	logDigest, err := r.Store.GetKey("log/" + digest)
+	if err != nil {
+		logger.V(3).Printf("no saved log for %s", name)
+		return nil
+	}
+	if err := r.Store.PutKey("logs/"+r.Build.ID+"/"+name, logDigest); err != nil {
+		return err
+	}
+	if !r.ReplayLogs {
+		return nil
+	}
+	f, err := r.Store.LoadStream(logDigest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		logger.Printf("> %s", scanner.Text())
+	}
+	return scanner.Err()
+}
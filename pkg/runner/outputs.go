@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/coldog/bld/pkg/fileutils"
+)
+
+// Publish writes everything a build produces outside of the cache, it is only
+// called once every step has succeeded.
+func (r *Runner) publish(ctx context.Context) error {
+	if err := r.writeOutputs(); err != nil {
+		return err
+	}
+	if err := r.writeImages(ctx); err != nil {
+		return err
+	}
+	return r.push(ctx)
+}
+
+// WriteOutputs copies exported sources to their output targets on the host.
+func (r *Runner) writeOutputs() error {
+	for _, out := range r.Build.Outputs {
+		if err := r.writeOutput(out); err != nil {
+			return fmt.Errorf("failed output %s: %v", out.Source, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) writeOutput(out builder.Output) error {
+	src := r.getSrcDir(out.Source)
+	if src == "" {
+		return fmt.Errorf("source not found")
+	}
+	target, err := outputTarget(out)
+	if err != nil {
+		return err
+	}
+	dest := r.dir(target)
+
+	if out.Untracked {
+		tracked, err := r.tracked(target)
+		if err != nil {
+			return err
+		}
+		if tracked {
+			return fmt.Errorf("target %s is tracked by git", out.Target)
+		}
+	}
+	if out.Clean {
+		r.logger.V(3).Printf("cleaning output target=%s", dest)
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+	}
+
+	r.logger.V(2).Printf("writing output source=%s target=%s", out.Source, dest)
+	return fileutils.Copy(src, dest, nil)
+}
+
+// outputTarget returns the target of an output relative to the root
+// directory. Targets are cleaned and copied over, so the root directory itself
+// and paths outside of it are rejected.
+func outputTarget(out builder.Output) (string, error) {
+	target := path.Clean(path.Join(out.Dir, out.Target))
+	if target == "." || target == ".." ||
+		strings.HasPrefix(target, "../") || path.IsAbs(target) {
+		return "", fmt.Errorf("target %q must be a directory inside the root directory", out.Target)
+	}
+	return target, nil
+}
+
+// Tracked returns true if git tracks any file at target. Nothing is tracked
+// if the root directory is not in a git repository.
+func (r *Runner) tracked(target string) (bool, error) {
+	cmd := exec.Command("git", "ls-files", "--", target)
+	cmd.Dir = r.RootDir
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok &&
+		strings.Contains(string(exitErr.Stderr), "not a git repository") {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("git ls-files: %v", err)
+	}
+	return strings.TrimSpace(string(out)) != "", nil
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coldog/bld/pkg/builder"
+)
+
+// PushReport describes a tag pushed to a registry.
+type PushReport struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+// Pushes returns the steps that have tags to push, if PushSteps is set only
+// those steps are returned.
+func (r *Runner) pushes() ([]builder.Step, error) {
+	steps := []builder.Step{}
+	if len(r.PushSteps) > 0 {
+		for _, name := range r.PushSteps {
+			step, ok := r.Build.Step(name)
+			if !ok {
+				if renamed, ok := r.Build.Renamed(name); ok {
+					return nil, fmt.Errorf("step not found: %s, did you mean %s", name, renamed)
+				}
+				return nil, fmt.Errorf("step not found: %s", name)
+			}
+			if step.Build == nil || len(step.Build.Tags()) == 0 {
+				return nil, fmt.Errorf("step %s has no tags to push", name)
+			}
+			steps = append(steps, step)
+		}
+		return steps, nil
+	}
+	for _, step := range r.Build.Steps {
+		if step.Build != nil && len(step.Build.Tags()) > 0 {
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
+
+// Push pushes the committed image of every step with push configured. It is
+// only called once the whole build has succeeded.
+func (r *Runner) push(ctx context.Context) error {
+	steps, err := r.pushes()
+	if err != nil {
+		return err
+	}
+	if len(steps) > 0 && r.Pusher == nil {
+		return fmt.Errorf("push is configured but no registry is available")
+	}
+	for _, step := range steps {
+		r.lock.RLock()
+		digest, ok := r.steps[step.Name]
+		r.lock.RUnlock()
+		if !ok {
+			return fmt.Errorf("step %s was not built", step.Name)
+		}
+		image := step.Name + ":" + digest
+		for _, tag := range step.Build.Tags() {
+			r.logger.Printf("pushing %s", tag)
+			pushed, err := r.Pusher.Push(ctx, image, tag)
+			if err != nil {
+				return fmt.Errorf("failed to push %s: %v", tag, err)
+			}
+			r.logger.V(2).Printf("pushed %s digest=%s", tag, pushed)
+			r.recordNode(step.Name, func(n *NodeReport) {
+				n.Pushed = append(n.Pushed, &PushReport{Tag: tag, Digest: pushed})
+			})
+		}
+	}
+	return nil
+}
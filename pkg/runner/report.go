@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/coldog/bld/pkg/builder"
+	"github.com/coldog/bld/pkg/fileutils"
+)
+
+// Node types.
+const (
+	NodeSource = "source"
+	NodeStep   = "step"
+)
+
+// Report is a machine readable summary of a build.
+type Report struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Checksum string        `json:"checksum"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration float64       `json:"duration"`
+	Nodes    []*NodeReport `json:"nodes"`
+}
+
+// NodeReport is the outcome of a single source or step. Durations are in
+// seconds.
+type NodeReport struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Digest   string          `json:"digest,omitempty"`
+	Status   Status          `json:"status"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	Duration float64         `json:"duration"`
+	ExitCode *int            `json:"exitCode,omitempty"`
+	ImageID  string          `json:"imageID,omitempty"`
+	Exports  []*ExportReport `json:"exports,omitempty"`
+	Pushed   []*PushReport   `json:"pushed,omitempty"`
+	Volatile []string        `json:"volatile,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// ExportReport describes a source exported by a step.
+type ExportReport struct {
+	Source string `json:"source"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// node returns the report for a node, the lock must be held.
+func (r *Runner) node(name string) *NodeReport {
+	if r.nodes == nil {
+		r.nodes = map[string]*NodeReport{}
+	}
+	n, ok := r.nodes[name]
+	if !ok {
+		n = &NodeReport{Name: name, Type: NodeStep}
+		if _, ok := isSource(name); ok {
+			n.Type = NodeSource
+		}
+		r.nodes[name] = n
+	}
+	return n
+}
+
+func (r *Runner) recordStart(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.node(name).Start = time.Now()
+}
+
+func (r *Runner) recordStatus(name string, status Status) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n := r.node(name)
+	n.Status = status
+	if !n.Start.IsZero() {
+		n.End = time.Now()
+		n.Duration = n.End.Sub(n.Start).Seconds()
+	}
+}
+
+func (r *Runner) recordNode(name string, fn func(n *NodeReport)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fn(r.node(name))
+}
+
+func (r *Runner) recordExitCode(name string, err error) {
+	var code *int
+	if err == nil {
+		code = new(int)
+	} else if exitErr, ok := err.(builder.ExitError); ok {
+		code = &exitErr.Code
+	}
+	r.recordNode(name, func(n *NodeReport) { n.ExitCode = code })
+}
+
+func (r *Runner) recordImage(ctx context.Context, name, digest string) {
+	imageID, err := r.ImageStore.ImageID(ctx, name, digest)
+	if err != nil {
+		r.logger.V(3).Printf("failed to inspect image %s: %v", name, err)
+		return
+	}
+	r.recordNode(name, func(n *NodeReport) { n.ImageID = imageID })
+}
+
+func (r *Runner) recordExport(name, source, digest, dir string) {
+	size, err := fileutils.Size(dir)
+	if err != nil {
+		r.logger.V(3).Printf("failed to size export %s: %v", source, err)
+	}
+	r.recordNode(name, func(n *NodeReport) {
+		n.Exports = append(n.Exports, &ExportReport{
+			Source: source,
+			Digest: digest,
+			Size:   size,
+		})
+	})
+}
+
+// Report returns the report for the last run, every source and step in the
+// build is listed. Nodes that never ran are marked as skipped.
+func (r *Runner) Report() Report {
+	checksum := r.checksum()
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	report := Report{
+		ID:       r.Build.ID,
+		Name:     r.Build.Name,
+		Checksum: checksum,
+		Start:    r.start,
+		End:      r.end,
+		Duration: r.end.Sub(r.start).Seconds(),
+	}
+	names := []string{}
+	for _, src := range r.Build.Sources {
+		names = append(names, "source/"+src.Name)
+	}
+	for _, step := range r.Build.Steps {
+		names = append(names, step.Name)
+	}
+	for _, name := range names {
+		n, ok := r.nodes[name]
+		if !ok {
+			n = &NodeReport{Name: name, Type: NodeStep, Status: StatusSkipped}
+			if _, ok := isSource(name); ok {
+				n.Type = NodeSource
+			}
+		}
+		if step, ok := r.Build.Step(name); ok {
+			if volatile := step.Volatile(); len(volatile) > 0 {
+				c := *n
+				c.Volatile = volatile
+				n = &c
+			}
+		}
+		report.Nodes = append(report.Nodes, n)
+	}
+	return report
+}
+
+// WriteReport writes the report for the last run as JSON to file.
+func (r *Runner) WriteReport(file string) error {
+	data, err := json.MarshalIndent(r.Report(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, fileutils.Regular)
+}
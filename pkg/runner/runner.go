@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path"
 	"sort"
 	"strings"
 	"sync"
@@ -14,10 +15,22 @@ import (
 	"github.com/coldog/bld/pkg/fileutils"
 	"github.com/coldog/bld/pkg/graph"
 	"github.com/coldog/bld/pkg/log"
+	"github.com/coldog/bld/pkg/registry"
 	"github.com/coldog/bld/pkg/store"
 	"github.com/davecgh/go-spew/spew"
 )
 
+// Status is the outcome of a node in the build graph.
+type Status string
+
+// Node statuses.
+const (
+	StatusRan     Status = "ran"
+	StatusCached  Status = "cached"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+)
+
 // Runner will run a specific build.
 type Runner struct {
 	Store      store.Store
@@ -29,7 +42,33 @@ type Runner struct {
 	Perform  func(ctx context.Context, step builder.StepExec) error
 	Workers  int
 
+	// KeepGoing will continue running every node that is not downstream of a
+	// failed node instead of exiting on the first failure.
+	KeepGoing bool
+
+	// NoCache lists steps that are always run, even if a cached result exists.
+	NoCache []string
+
+	// ReplayLogs prints the saved output of steps restored from the cache.
+	ReplayLogs bool
+
+	// ReportsDir is where the reports declared by steps are collected.
+	ReportsDir string
+
+	// ResolveImage returns the digest of a service image, it is included in
+	// the digest of steps with services.
+	ResolveImage func(ctx context.Context, image string) (string, error)
+
+	// Pusher pushes the images of steps with push configured once the build
+	// succeeds. PushSteps restricts pushing to the listed steps.
+	Pusher    registry.Pusher
+	PushSteps []string
+
 	steps map[string]string
+	nodes map[string]*NodeReport
+
+	start time.Time
+	end   time.Time
 
 	logger        log.Logger
 	lock          sync.RWMutex
@@ -46,6 +85,15 @@ func (r *Runner) recordStep(name, digest string) {
 	r.steps[name] = digest
 }
 
+func (r *Runner) noCache(name string) bool {
+	for _, n := range r.NoCache {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
 // AddSrc goes through the workflow of adding a source directory.
 func (r *Runner) addSrc(name, target string, files []string, copy bool) error {
 	if err := os.MkdirAll(target, fileutils.Directory); err != nil {
@@ -122,17 +170,41 @@ func (r *Runner) collectSources() map[string]string {
 		m[name] = dir
 	}
 	for _, vol := range r.Build.Volumes {
-		m[vol.Name] = vol.Target
+		if path.IsAbs(vol.Target) {
+			m[vol.Name] = vol.Target
+		} else {
+			m[vol.Name] = r.dir(vol.Dir, vol.Target)
+		}
 	}
 	return m
 }
 
-func (r *Runner) dir(name string) string { return r.RootDir + "/" + name }
+// dir returns the path of a file relative to the root directory, names are
+// joined.
+func (r *Runner) dir(names ...string) string { return r.RootDir + "/" + path.Join(names...) }
 
 func (r *Runner) sourceMountDir(name string) string {
 	return r.BuildDir + "/sources/mount/" + r.Build.ID + "/" + name + "/"
 }
 
+func (r *Runner) workspaceDir() string {
+	return r.BuildDir + "/workspaces/" + r.Build.ID
+}
+
+// Cleanup removes the directories scoped to this build.
+func (r *Runner) cleanup() {
+	for _, dir := range []string{
+		r.workspaceDir(),
+		r.BuildDir + "/sources/mount/" + r.Build.ID,
+		r.BuildDir + "/logs/" + r.Build.ID,
+	} {
+		r.logger.V(3).Printf("removing build dir %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			r.logger.Printf("failed to remove build dir %s: %v", dir, err)
+		}
+	}
+}
+
 func (r *Runner) sourceWorkDir(digest string) string {
 	return r.BuildDir + "/sources/work/" + digest + "/"
 }
@@ -146,59 +218,140 @@ func (r *Runner) sourceWorkDir(digest string) string {
 // If the digest does not exist:
 //   3. Prepare exports for mounting.
 //   4. Run the step and save all exports.
-func (r *Runner) runStep(ctx context.Context, step builder.Step) error {
+func (r *Runner) runStep(ctx context.Context, step builder.Step) (err error) {
 	start := time.Now()
 
 	imports := []string{step.Digest()}
-	for _, imp := range step.Imports {
-		imports = append(imports, r.getSrcDigest(imp.Source))
+	for _, src := range step.Sources() {
+		imports = append(imports, r.getSrcDigest(src))
+	}
+	for _, svc := range step.Services {
+		if r.ResolveImage == nil {
+			break
+		}
+		imageDigest, err := r.ResolveImage(ctx, svc.Image)
+		if err != nil {
+			return fmt.Errorf("failed to resolve service image %s: %v", svc.Image, err)
+		}
+		imports = append(imports, imageDigest)
 	}
 	digest := content.DigestStrings(imports...)
 	r.recordStep(step.Name, digest)
+	r.recordNode(step.Name, func(n *NodeReport) { n.Digest = digest })
 
-	logger := r.logger.Prefix(r.Build.Name + "/" + step.Name)
+	logger := log.ContextGetLogger(ctx).
+		Prefix(r.Build.Name+"/"+step.Name).
+		With("step", step.Name)
 	logger.Printf("STEP: %s (%s)", step.Name, digest)
+	logger.Event(log.Event{Type: log.EventStepStarted, Digest: digest})
+	defer func() {
+		if err != nil {
+			logger.Event(log.Event{
+				Type:     log.EventStepFailed,
+				Digest:   digest,
+				Duration: time.Since(start),
+				Err:      err,
+			})
+		}
+	}()
 
 	if _, err := r.Store.GetKey(
 		"step/" + digest,
-	); err == nil {
-		if step.Build != nil {
+	); err == nil && !r.noCache(step.Name) {
+		if step.Commits() {
 			// Restore the built image.
 			logger.V(3).Printf("pulling image %s", digest)
 			if err := r.ImageStore.Restore(ctx, step.Name, digest); err != nil {
 				return err
 			}
+			r.recordImage(ctx, step.Name, digest)
+			if err := r.linkImage(step.Name, digest); err != nil {
+				return err
+			}
+		}
+
+		if err := r.replayLog(logger, step.Name, digest); err != nil {
+			return err
 		}
 
 		logger.V(5).Printf("restoring exports digest=%s step=%+v", digest, step)
 		logger.Printf("> %s: step cached (%v)", step.Name, time.Since(start))
-		return r.restoreExports(ctx, digest, step)
+		if err := r.restoreExports(ctx, digest, step); err != nil {
+			return err
+		}
+		if err := r.collectReports(step); err != nil {
+			return err
+		}
+		r.recordStatus(step.Name, StatusCached)
+		logger.Event(log.Event{
+			Type:     log.EventStepCached,
+			Digest:   digest,
+			Duration: time.Since(start),
+		})
+		return nil
 	}
 	logger.V(5).Printf("running step digest=%s step=%+v", digest, step)
 
+	names := []string{}
+	for _, secret := range step.Secrets {
+		names = append(names, secret.Name)
+	}
+	secrets, err := r.secrets(names)
+	if err != nil {
+		return err
+	}
+
 	if err := r.prepareExports(ctx, step); err != nil {
 		return err
 	}
 
+	output, err := r.createLog(step.Name)
+	if err != nil {
+		return err
+	}
+
 	ctx = log.ContextWithLogger(ctx, logger)
 	exec := builder.StepExec{
-		Digest:     digest,
-		Step:       step,
-		SourceDirs: r.collectSources(),
-		BuildDir:   r.BuildDir,
-		BuildID:    r.Build.ID,
-		RootDir:    r.RootDir,
+		Digest:       digest,
+		Step:         step,
+		SourceDirs:   r.collectSources(),
+		BuildDir:     r.BuildDir,
+		BuildID:      r.Build.ID,
+		RootDir:      r.RootDir,
+		Output:       output,
+		SecretValues: secrets,
 	}
 	logger.V(5).Printf("executing step: %+v", exec)
-	if err := r.Perform(ctx, exec); err != nil {
+	err = r.Perform(ctx, exec)
+	output.Close()
+	r.recordExitCode(step.Name, err)
+
+	// Output and reports are saved for failed steps as well.
+	if serr := r.saveLog(step.Name, digest); serr != nil {
+		logger.Printf("failed to save log: %v", serr)
+	}
+	if cerr := r.collectReports(step); cerr != nil {
+		logger.Printf("failed to collect reports: %v", cerr)
+	}
+	if err != nil {
 		return err
 	}
 
-	if step.Build != nil {
+	// A cancelled build must not record the step, its exports may only have
+	// been partially written by the container.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if step.Commits() {
 		logger.V(3).Printf("saving image %s", digest)
 		if err := r.ImageStore.Save(ctx, step.Name, digest); err != nil {
 			return err
 		}
+		r.recordImage(ctx, step.Name, digest)
+		if err := r.linkImage(step.Name, digest); err != nil {
+			return err
+		}
 	}
 
 	logger.V(5).Printf("saving exports %+v", step.Exports)
@@ -207,7 +360,22 @@ func (r *Runner) runStep(ctx context.Context, step builder.Step) error {
 	}
 
 	logger.Printf("> %s: step finished (%v)", step.Name, time.Since(start))
-	return r.Store.PutKey("step/"+digest, "")
+	if err := r.Store.PutKey("step/"+digest, ""); err != nil {
+		return err
+	}
+	r.recordStatus(step.Name, StatusRan)
+	logger.Event(log.Event{
+		Type:     log.EventStepFinished,
+		Digest:   digest,
+		Duration: time.Since(start),
+	})
+	return nil
+}
+
+// exportKey maps a step digest and one of its exported sources to the digest
+// of the saved source.
+func exportKey(digest, source string) string {
+	return "exports/" + digest + "/" + source
 }
 
 // RestoreExports will mount exports from the store.
@@ -217,7 +385,12 @@ func (r *Runner) restoreExports(
 		var key string
 		{
 			var err error
-			key, err = r.Store.GetKey("export/" + digest)
+			key, err = r.Store.GetKey(exportKey(digest, exp.Source))
+			if err != nil {
+				// Steps cached before exports were keyed by source have a
+				// single key for all their exports.
+				key, err = r.Store.GetKey("export/" + digest)
+			}
 			if err != nil {
 				return fmt.Errorf("failed export %s: %v", exp.Source, err)
 			}
@@ -230,6 +403,7 @@ func (r *Runner) restoreExports(
 		if err := r.addSrc(exp.Source, dir, nil, false); err != nil {
 			return fmt.Errorf("failed to restore %s: %v", exp.Source, err)
 		}
+		r.recordExport(step.Name, exp.Source, key, dir)
 	}
 	return nil
 }
@@ -257,13 +431,14 @@ func (r *Runner) saveExports(
 		}
 		sourceDigest := r.getSrcDigest(exp.Source)
 		if err := r.Store.PutKey(
-			"export/"+digest, sourceDigest,
+			exportKey(digest, exp.Source), sourceDigest,
 		); err != nil {
 			return fmt.Errorf("failed to get export %s: %v", exp.Source, err)
 		}
 		if err := r.Store.Save(sourceDigest, dir); err != nil {
 			return err
 		}
+		r.recordExport(step.Name, exp.Source, sourceDigest, dir)
 	}
 	return nil
 }
@@ -274,14 +449,21 @@ func (r *Runner) saveExports(
 func (r *Runner) runSource(ctx context.Context, src builder.Source) error {
 	r.logger.V(3).Printf(
 		"adding source name=%s target=%s",
-		src.Name, r.dir(src.Target),
+		src.Name, r.dir(src.Dir, src.Target),
 	)
-	return r.addSrc(src.Name, r.dir(src.Target), src.Files, true)
+	if err := r.addSrc(src.Name, r.dir(src.Dir, src.Target), src.Files, true); err != nil {
+		return err
+	}
+	digest := r.getSrcDigest(src.Name)
+	r.recordNode("source/"+src.Name, func(n *NodeReport) { n.Digest = digest })
+	r.recordStatus("source/"+src.Name, StatusRan)
+	return nil
 }
 
 // Run will run a given target. It expects source targets to match:
 // `source/name`.
 func (r *Runner) run(ctx context.Context, name string) error {
+	r.recordStart(name)
 	sourceName, ok := isSource(name)
 	if ok {
 		source, ok := r.Build.Source(sourceName)
@@ -322,42 +504,72 @@ func (r *Runner) Run(ctx context.Context) error {
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	// Step workspaces, source mounts and scratch logs are only needed while the
+	// build runs, saved outputs and logs are in the store.
+	defer r.cleanup()
 
-	log := r.logger.Prefix(r.Build.Name)
-	r.logger = log
+	start := time.Now()
+	r.start = start
+	defer func() { r.end = time.Now() }()
+	logger := r.logger.Prefix(r.Build.Name).With("build", r.Build.ID)
+	r.logger = logger
 
 	errs := make(chan error, r.Workers)
 	wg := &sync.WaitGroup{}
 	wg.Add(r.Workers)
 
-	log.Printf("starting build %s", r.Build.ID)
-	log.V(5).Printf("%s", spew.Sdump(r.Build))
+	logger.Printf("starting build %s", r.Build.ID)
+	if err := r.Store.PutKey(latestBuildKey, r.Build.ID); err != nil {
+		return err
+	}
+	logger.V(5).Printf("%s", spew.Sdump(r.Build))
 
 	s.Solve()
 
+	logger.Event(log.Event{Type: log.EventBuildStarted})
+	for _, step := range r.Build.Steps {
+		logger.With("step", step.Name).Event(log.Event{Type: log.EventStepQueued})
+		if volatile := step.Volatile(); len(volatile) > 0 {
+			logger.Printf("step %s is not cacheable across commits, it depends on %s",
+				step.Name, strings.Join(volatile, ", "))
+		}
+	}
+
 	for i := 0; i < r.Workers; i++ {
 		go func(i int) {
-			log := log.Prefix(fmt.Sprintf("%s/%d", r.Build.ID, i))
+			logger := logger.Prefix(fmt.Sprintf("%s/%d", r.Build.ID, i)).With("worker", i)
 			defer wg.Done()
-			defer log.V(2).Printf("worker exited id=%d", i)
+			defer logger.V(2).Printf("worker exited id=%d", i)
 
 			for {
 				id, err := s.Select(ctx)
 				if err == graph.ErrFinished {
 					return
 				}
+				if err == graph.ErrSkipped {
+					logger.V(2).Printf("skipping step id=%s", id)
+					r.recordStatus(id, StatusSkipped)
+					continue
+				}
 				if err != nil {
 					errs <- err
 					return
 				}
-				log.V(2).Printf("starting step id=%s", id)
-				err = r.run(ctx, id)
+				logger.V(2).Printf("starting step id=%s", id)
+				err = r.run(log.ContextWithLogger(ctx, logger), id)
 				if err != nil {
-					log.V(2).Printf("step failed id=%s: %v", id, err)
+					logger.V(2).Printf("step failed id=%s: %v", id, err)
+					r.recordStatus(id, StatusFailed)
+					r.recordNode(id, func(n *NodeReport) { n.Error = err.Error() })
+					if r.KeepGoing {
+						logger.Printf("step failed %s: %v", id, err)
+						s.Fail(id)
+						continue
+					}
 					errs <- err
 					return
 				}
-				log.V(2).Printf("finished step id=%s", id)
+				logger.V(2).Printf("finished step id=%s", id)
 				s.Done(id)
 			}
 		}(i)
@@ -369,14 +581,67 @@ func (r *Runner) Run(ctx context.Context) error {
 		s.Close()
 	}()
 
+	// Wait for all in flight steps to exit after the first error so that their
+	// containers are stopped before returning.
+	var err error
 	for e := range errs {
-		return e
+		if err == nil {
+			err = e
+			cancel()
+		}
+	}
+	if err == nil && r.KeepGoing {
+		if failed := r.summary(); failed > 0 {
+			err = fmt.Errorf("%d steps failed", failed)
+		}
+	}
+	if err != nil {
+		logger.Event(log.Event{
+			Type:     log.EventBuildFinished,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+		return err
 	}
 
-	log.Printf("finished (%s)", r.checksum())
+	if err := r.publish(ctx); err != nil {
+		logger.Event(log.Event{
+			Type:     log.EventBuildFinished,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+		return err
+	}
+
+	checksum := r.checksum()
+	logger.Printf("finished (%s)", checksum)
+	logger.Event(log.Event{
+		Type:     log.EventBuildFinished,
+		Digest:   checksum,
+		Duration: time.Since(start),
+	})
 	return nil
 }
 
+// Summary logs the status of every node in the build, it returns the number of
+// failed nodes.
+func (r *Runner) summary() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	byStatus := map[Status][]string{}
+	for name, n := range r.nodes {
+		byStatus[n.Status] = append(byStatus[n.Status], name)
+	}
+	for _, status := range []Status{
+		StatusRan, StatusCached, StatusFailed, StatusSkipped,
+	} {
+		names := byStatus[status]
+		sort.Strings(names)
+		r.logger.Printf("%s (%d): %s", status, len(names), strings.Join(names, ", "))
+	}
+	return len(byStatus[StatusFailed])
+}
+
 func isSource(name string) (string, bool) {
 	spl := strings.Split(name, "/")
 	if spl[0] == "source" {
@@ -1,10 +1,17 @@
 package runner
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/coldog/bld/pkg/builder"
@@ -17,10 +24,34 @@ type mockImageStore struct{}
 
 func (mockImageStore) Save(ctx context.Context, name, id string) error    { return nil }
 func (mockImageStore) Restore(ctx context.Context, name, id string) error { return nil }
+func (mockImageStore) ImageID(ctx context.Context, name, id string) (string, error) {
+	return "sha256:" + id, nil
+}
+func (mockImageStore) Export(ctx context.Context, name, id string, w io.Writer) error {
+	_, err := w.Write([]byte(name + ":" + id))
+	return err
+}
+
+// memoryPusher records the digest of each pushed tag, nothing leaves the
+// process.
+type memoryPusher struct {
+	lock sync.Mutex
+	Tags map[string]string
+}
+
+func newMemoryPusher() *memoryPusher { return &memoryPusher{Tags: map[string]string{}} }
+
+func (m *memoryPusher) Push(ctx context.Context, image, tag string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h := sha256.Sum256([]byte(image))
+	digest := "sha256:" + hex.EncodeToString(h[:])
+	m.Tags[tag] = digest
+	return digest, nil
+}
 
 var (
 	wd   string
-	tmp  string
 	noop = func(ctx context.Context, exec builder.StepExec) error {
 		return nil
 	}
@@ -31,29 +62,53 @@ var (
 
 func init() {
 	wd, _ = os.Getwd()
-	tmp, _ = ioutil.TempDir("", "")
 }
 
-func test(
+// option changes a runner created by newRunner.
+type option func(r *Runner)
+
+// inDir shares the build directory and store of another runner, so steps are
+// cached between the runs.
+func inDir(other *Runner) option {
+	return func(r *Runner) {
+		r.BuildDir = other.BuildDir
+		r.Store = other.Store
+	}
+}
+
+// newRunner returns a runner for the build with its own build directory and
+// store, so cache state is never shared between tests.
+func newRunner(
 	t *testing.T, build builder.Build,
-	fn func(ctx context.Context, exec builder.StepExec) error) error {
+	fn func(ctx context.Context, exec builder.StepExec) error, opts ...option) *Runner {
 
 	log.Level(4)
 
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
 	r := &Runner{
 		ImageStore: mockImageStore{},
-		Store:      store.NewLocalStore(tmp),
-		BuildDir:   tmp,
+		Store:      store.NewLocalStore(dir),
+		BuildDir:   dir,
 		RootDir:    wd,
 		Build:      build,
 		Workers:    2,
 		Perform:    fn,
 	}
-	return r.Run(context.Background())
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func test(
+	t *testing.T, build builder.Build,
+	fn func(ctx context.Context, exec builder.StepExec) error, opts ...option) error {
+	return newRunner(t, build, fn, opts...).Run(context.Background())
 }
 
 func TestRunner(t *testing.T) {
-	err := test(t, builder.Build{
+	r := newRunner(t, builder.Build{
 		ID:   "10",
 		Name: "test",
 		Sources: []builder.Source{
@@ -71,11 +126,19 @@ func TestRunner(t *testing.T) {
 			},
 		},
 	}, noop)
-	require.Nil(t, err)
+	require.NoError(t, r.Run(context.Background()))
+	require.Equal(t, StatusRan, r.nodes["s1"].Status)
+	require.Equal(t, StatusRan, r.nodes["s2"].Status)
+
+	// Temporary step state is removed once the build finishes.
+	for _, dir := range []string{"/workspaces/10", "/sources/mount/10", "/logs/10"} {
+		_, err := os.Stat(r.BuildDir + dir)
+		require.True(t, os.IsNotExist(err), dir)
+	}
 }
 
 func TestRunnerCached(t *testing.T) {
-	err := test(t, builder.Build{
+	build := builder.Build{
 		ID:   "10",
 		Name: "test",
 		Sources: []builder.Source{
@@ -92,8 +155,15 @@ func TestRunnerCached(t *testing.T) {
 				Imports: []builder.Mount{{Source: "r2", Mount: "/usr/src/app"}},
 			},
 		},
-	}, noop)
-	require.Nil(t, err)
+	}
+	first := newRunner(t, build, noop)
+	require.NoError(t, first.Run(context.Background()))
+
+	build.ID = "11"
+	r := newRunner(t, build, fail, inDir(first))
+	require.NoError(t, r.Run(context.Background()))
+	require.Equal(t, StatusCached, r.nodes["s1"].Status)
+	require.Equal(t, StatusCached, r.nodes["s2"].Status)
 }
 
 func TestRunnerFailing(t *testing.T) {
@@ -117,3 +187,511 @@ func TestRunnerFailing(t *testing.T) {
 	}, fail)
 	require.Error(t, err)
 }
+
+func TestRunnerKeepGoing(t *testing.T) {
+	r := newRunner(t, builder.Build{
+		ID:   "10",
+		Name: "test-keep-going",
+		Sources: []builder.Source{
+			{Name: "r1", Target: "testdata"},
+		},
+		Steps: []builder.Step{
+			{
+				Name:    "kg1",
+				Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+				Exports: []builder.Mount{{Source: "kg-r2", Mount: "/usr/src/app2"}},
+			},
+			{
+				Name:    "kg2",
+				Imports: []builder.Mount{{Source: "kg-r2", Mount: "/usr/src/app"}},
+			},
+			{
+				Name:    "kg3",
+				Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+			},
+		},
+	}, func(ctx context.Context, exec builder.StepExec) error {
+		if exec.Name == "kg1" {
+			return errors.New("some err")
+		}
+		return nil
+	}, func(r *Runner) { r.KeepGoing = true })
+	err := r.Run(context.Background())
+	require.Error(t, err)
+	require.Equal(t, StatusFailed, r.nodes["kg1"].Status)
+	require.Equal(t, StatusSkipped, r.nodes["kg2"].Status)
+	require.Equal(t, StatusRan, r.nodes["kg3"].Status)
+}
+
+func TestRunnerCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := newRunner(t, builder.Build{
+		ID:   "cancelled",
+		Name: "test-cancelled",
+		Sources: []builder.Source{
+			{Name: "r1", Target: "testdata"},
+		},
+		Steps: []builder.Step{
+			{
+				Name:    "c1",
+				Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+				Exports: []builder.Mount{{Source: "c-r2", Mount: "/usr/src/app2"}},
+			},
+		},
+	}, func(ctx context.Context, exec builder.StepExec) error {
+		cancel()
+		return nil
+	})
+	err := r.Run(ctx)
+	require.Error(t, err)
+
+	_, err = r.Store.GetKey("step/" + r.steps["c1"])
+	require.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(r.BuildDir + "/sources/mount/cancelled")
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(r.BuildDir + "/logs/cancelled")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRunnerNoCache(t *testing.T) {
+	runs := 0
+	build := builder.Build{
+		ID:   "10",
+		Name: "test-no-cache",
+		Sources: []builder.Source{
+			{Name: "r1", Target: "testdata"},
+		},
+		Steps: []builder.Step{
+			{
+				Name:    "nc1",
+				Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+			},
+		},
+	}
+	count := func(ctx context.Context, exec builder.StepExec) error {
+		runs++
+		return nil
+	}
+	noCache := func(r *Runner) { r.NoCache = []string{"nc1"} }
+
+	first := newRunner(t, build, count, noCache)
+	require.NoError(t, first.Run(context.Background()))
+	r := newRunner(t, build, count, noCache, inDir(first))
+	require.NoError(t, r.Run(context.Background()))
+	require.Equal(t, 2, runs)
+	require.Equal(t, StatusRan, r.nodes["nc1"].Status)
+}
+
+func TestRunnerLogs(t *testing.T) {
+	build := builder.Build{
+		Name: "test-logs",
+		Sources: []builder.Source{
+			{Name: "r1", Target: "testdata"},
+		},
+		Steps: []builder.Step{
+			{
+				Name:    "l1",
+				Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+			},
+		},
+	}
+
+	perform := func(ctx context.Context, exec builder.StepExec) error {
+		_, err := exec.Output.Write([]byte("hello logs\n"))
+		return err
+	}
+	replay := func(r *Runner) { r.ReplayLogs = true }
+
+	build.ID = "logs-1"
+	first := newRunner(t, build, perform, replay)
+	require.NoError(t, first.Run(context.Background()))
+	build.ID = "logs-2"
+	r := newRunner(t, build, perform, replay, inDir(first))
+	require.NoError(t, r.Run(context.Background()))
+	require.Equal(t, StatusCached, r.nodes["l1"].Status)
+
+	for _, id := range []string{"logs-1", ""} {
+		rc, err := Logs(r.Store, id, "l1")
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.Equal(t, "hello logs\n", string(data))
+	}
+}
+
+func TestRunnerReport(t *testing.T) {
+	r := newRunner(t, builder.Build{
+		ID:   "report",
+		Name: "test-report",
+		Sources: []builder.Source{
+			{Name: "r1", Target: "testdata"},
+		},
+		Steps: []builder.Step{
+			{
+				Name:    "rp1",
+				Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+				Exports: []builder.Mount{{Source: "rp-r2", Mount: "/usr/src/app2"}},
+			},
+			{
+				Name:    "rp2",
+				Imports: []builder.Mount{{Source: "rp-r2", Mount: "/usr/src/app"}},
+				Inputs:  &builder.Inputs{Git: []string{"sha"}},
+			},
+		},
+	}, func(ctx context.Context, exec builder.StepExec) error {
+		if exec.Name == "rp2" {
+			return builder.ExitError{Code: 2}
+		}
+		return nil
+	}, func(r *Runner) { r.KeepGoing = true })
+	require.Error(t, r.Run(context.Background()))
+
+	file := r.BuildDir + "/report.json"
+	require.NoError(t, r.WriteReport(file))
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, "report", report.ID)
+	require.Len(t, report.Nodes, 3)
+
+	nodes := map[string]*NodeReport{}
+	for _, n := range report.Nodes {
+		nodes[n.Name] = n
+	}
+	require.Equal(t, NodeSource, nodes["source/r1"].Type)
+	require.Equal(t, StatusRan, nodes["source/r1"].Status)
+
+	require.Equal(t, StatusRan, nodes["rp1"].Status)
+	require.Equal(t, 0, *nodes["rp1"].ExitCode)
+	require.Len(t, nodes["rp1"].Exports, 1)
+	require.Equal(t, "rp-r2", nodes["rp1"].Exports[0].Source)
+
+	require.Equal(t, StatusFailed, nodes["rp2"].Status)
+	require.Equal(t, 2, *nodes["rp2"].ExitCode)
+	require.Equal(t, []string{"git.sha"}, nodes["rp2"].Volatile)
+	require.Empty(t, nodes["rp1"].Volatile)
+}
+
+func TestRunnerJUnit(t *testing.T) {
+	reportsDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+
+	r := newRunner(t, builder.Build{
+		ID:   "junit",
+		Name: "test-junit",
+		Sources: []builder.Source{
+			{Name: "r1", Target: "testdata"},
+		},
+		Steps: []builder.Step{
+			{
+				Name:    "ju1",
+				Imports: []builder.Mount{{Source: "r1", Mount: "/usr/src/app"}},
+				Exports: []builder.Mount{{Source: "ju-results", Mount: "/results"}},
+				Reports: []builder.Report{{Source: "ju-results", Path: "junit/*.xml"}},
+			},
+		},
+	}, func(ctx context.Context, exec builder.StepExec) error {
+		dir := exec.SourceDirs["ju-results"] + "/junit"
+		os.MkdirAll(dir, 0700)
+		ioutil.WriteFile(dir+"/a.xml", []byte("<testsuite/>"), 0600)
+		exec.Output.Write([]byte("1 test failed\n"))
+		return builder.ExitError{Code: 1}
+	}, func(r *Runner) { r.ReportsDir = reportsDir })
+	require.Error(t, r.Run(context.Background()))
+
+	data, err := ioutil.ReadFile(reportsDir + "/ju1/a.xml")
+	require.NoError(t, err)
+	require.Equal(t, "<testsuite/>", string(data))
+
+	file := reportsDir + "/bld.xml"
+	require.NoError(t, r.WriteJUnit(file))
+	data, err = ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `<testsuite name="test-junit" tests="1" failures="1"`)
+	require.Contains(t, string(data), `<failure message="container: exit code 1">1 test failed`)
+}
+
+func TestRunnerOutputs(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(root+"/src", 0700))
+	require.NoError(t, ioutil.WriteFile(root+"/src/main.c", []byte("main"), 0600))
+	require.NoError(t, os.MkdirAll(root+"/dist", 0700))
+	require.NoError(t, ioutil.WriteFile(root+"/dist/stale", []byte("stale"), 0600))
+
+	err = test(t, builder.Build{
+		ID:   "outputs",
+		Name: "test-outputs",
+		Sources: []builder.Source{
+			{Name: "src", Target: "src"},
+		},
+		Steps: []builder.Step{
+			{
+				Name:    "out1",
+				Imports: []builder.Mount{{Source: "src", Mount: "/src"}},
+				Exports: []builder.Mount{{Source: "out-bin", Mount: "/bin"}},
+			},
+		},
+		Outputs: []builder.Output{
+			{Source: "out-bin", Target: "dist", Clean: true, Untracked: true},
+		},
+	}, func(ctx context.Context, exec builder.StepExec) error {
+		return ioutil.WriteFile(exec.SourceDirs["out-bin"]+"/main", []byte("bin"), 0600)
+	}, func(r *Runner) { r.RootDir = root })
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(root + "/dist/main")
+	require.NoError(t, err)
+	require.Equal(t, "bin", string(data))
+
+	_, err = os.Stat(root + "/dist/stale")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestOutputTarget(t *testing.T) {
+	for _, out := range []builder.Output{
+		{Target: "dist"},
+		{Target: "./dist/"},
+		{Target: ".", Dir: "services/api"},
+		{Target: "../web/dist", Dir: "services/api"},
+	} {
+		_, err := outputTarget(out)
+		require.NoError(t, err, out.Target)
+	}
+	for _, out := range []builder.Output{
+		{Target: ""},
+		{Target: "."},
+		{Target: "dist/.."},
+		{Target: "../x"},
+		{Target: "/tmp/x"},
+		{Target: "../../..", Dir: "services/api"},
+	} {
+		_, err := outputTarget(out)
+		require.Error(t, err, out.Target)
+	}
+}
+
+func TestRunnerPush(t *testing.T) {
+	build := func(push *builder.Push) builder.Build {
+		return builder.Build{
+			ID:   "push",
+			Name: "test-push",
+			Steps: []builder.Step{
+				{Name: "push1", Build: &builder.Image{Tag: "repo/push1:latest", Push: push}},
+				{Name: "push2"},
+			},
+		}
+	}
+
+	t.Run("Tag", func(t *testing.T) {
+		reg := newMemoryPusher()
+		r := newRunner(t, build(&builder.Push{Enabled: true}), noop,
+			func(r *Runner) { r.Pusher = reg })
+		require.NoError(t, r.Run(context.Background()))
+		require.Contains(t, reg.Tags, "repo/push1:latest")
+
+		report := r.Report()
+		require.Equal(t, "push1", report.Nodes[0].Name)
+		require.Equal(t, []*PushReport{
+			{Tag: "repo/push1:latest", Digest: reg.Tags["repo/push1:latest"]},
+		}, report.Nodes[0].Pushed)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		reg := newMemoryPusher()
+		b := build(&builder.Push{Tags: []string{"repo/push1:v1"}})
+		b.Steps[1].Imports = []builder.Mount{{Source: "push1-out", Mount: "/out"}}
+		b.Steps[0].Exports = []builder.Mount{{Source: "push1-out", Mount: "/out"}}
+		err := test(t, b, func(ctx context.Context, exec builder.StepExec) error {
+			if exec.Name == "push2" {
+				return errors.New("some err")
+			}
+			return nil
+		}, func(r *Runner) { r.Pusher = reg })
+		require.Error(t, err)
+		require.Empty(t, reg.Tags)
+	})
+
+	t.Run("Steps", func(t *testing.T) {
+		err := test(t, build(nil), noop, func(r *Runner) {
+			r.Pusher = newMemoryPusher()
+			r.PushSteps = []string{"push2"}
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestRunnerImageExport(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	abs, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+
+	r := newRunner(t, builder.Build{
+		ID:   "image-export",
+		Name: "test-image-export",
+		Steps: []builder.Step{
+			{Name: "oci1", Build: &builder.Image{Tag: "oci1", OCI: "dist/oci1.tar"}},
+			{Name: "oci2", Build: &builder.Image{Tag: "oci2", OCI: "dist/oci2.tar", Dir: "sub"}},
+			{Name: "oci3", Build: &builder.Image{Tag: "oci3", OCI: abs + "/oci3.tar", Dir: "sub"}},
+		},
+	}, noop, func(r *Runner) { r.RootDir = root })
+	require.NoError(t, r.Run(context.Background()))
+
+	digest, err := ImageDigest(r.Store, "", "oci1")
+	require.NoError(t, err)
+	require.Equal(t, r.steps["oci1"], digest)
+
+	data, err := ioutil.ReadFile(root + "/dist/oci1.tar")
+	require.NoError(t, err)
+	require.Equal(t, "oci1:"+digest, string(data))
+
+	// Relative paths are resolved against the directory of the build file.
+	_, err = os.Stat(root + "/sub/dist/oci2.tar")
+	require.NoError(t, err)
+	_, err = os.Stat(abs + "/oci3.tar")
+	require.NoError(t, err)
+}
+
+func TestRunnerSecrets(t *testing.T) {
+	os.Setenv("BLD_TEST_SECRET", "s3cr3t-value")
+	defer os.Unsetenv("BLD_TEST_SECRET")
+
+	var secrets map[string]string
+	build := builder.Build{
+		ID:   "secrets",
+		Name: "test-secrets",
+		Secrets: []builder.Secret{
+			{Name: "token", Env: "BLD_TEST_SECRET"},
+		},
+		Steps: []builder.Step{
+			{Name: "secret1", Secrets: []builder.SecretMount{{Name: "token", Env: "TOKEN"}}},
+		},
+	}
+	err := test(t, build, func(ctx context.Context, exec builder.StepExec) error {
+		secrets = exec.SecretValues
+		log.ContextGetLogger(ctx).Printf("token %s", exec.SecretValues["token"])
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"token": "s3cr3t-value"}, secrets)
+	require.Equal(t, "******", log.Redact("s3cr3t-value"))
+
+	build.Steps[0].Name = "secret2"
+	build.Steps[0].Secrets[0].Name = "missing"
+	build.ID = "secrets-missing"
+	err = test(t, build, noop)
+	require.Error(t, err)
+}
+
+func TestRunnerSecretFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("file-s3cr3t\n")
+	require.NoError(t, err)
+	f.Close()
+
+	buf := bytes.NewBuffer(nil)
+	var secrets map[string]string
+	build := builder.Build{
+		ID:   "secrets-file",
+		Name: "test-secrets-file",
+		Secrets: []builder.Secret{
+			{Name: "key", File: f.Name()},
+		},
+		Steps: []builder.Step{
+			{Name: "secret-file", Secrets: []builder.SecretMount{{Name: "key", File: "key"}}},
+		},
+	}
+	err = test(t, build, func(ctx context.Context, exec builder.StepExec) error {
+		secrets = exec.SecretValues
+		// Output is redacted line by line, as if the step ran `cat key`.
+		log.Logger{}.Output(buf).Print(strings.TrimSpace(exec.SecretValues["key"]))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key": "file-s3cr3t\n"}, secrets)
+	require.NotContains(t, buf.String(), "s3cr3t")
+}
+
+func TestRunnerSecretPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	r := &Runner{RootDir: "/root"}
+	for _, tc := range []struct{ dir, file, path string }{
+		{"", "key", "/root/key"},
+		{"sub", "key", "/root/sub/key"},
+		{"sub", "/etc/key", "/etc/key"},
+		{"sub", "~/.npmrc", home + "/.npmrc"},
+	} {
+		p, err := r.secretFile(tc.dir, tc.file)
+		require.NoError(t, err)
+		require.Equal(t, tc.path, p)
+	}
+}
+
+func TestRunnerServiceDigest(t *testing.T) {
+	images := map[string]string{"postgres:10": "postgres@sha256:1"}
+	run := func(id string) string {
+		r := newRunner(t, builder.Build{
+			ID:   id,
+			Name: "test-services",
+			Steps: []builder.Step{
+				{Name: "svc1", Services: []builder.Service{{Name: "db", Image: "postgres:10"}}},
+			},
+		}, noop, func(r *Runner) {
+			r.ResolveImage = func(ctx context.Context, image string) (string, error) {
+				return images[image], nil
+			}
+		})
+		require.NoError(t, r.Run(context.Background()))
+		return r.steps["svc1"]
+	}
+
+	digest := run("services-1")
+	require.Equal(t, digest, run("services-2"))
+
+	images["postgres:10"] = "postgres@sha256:2"
+	require.NotEqual(t, digest, run("services-3"))
+}
+
+func TestRunnerSourceDir(t *testing.T) {
+	var volume string
+	r := newRunner(t, builder.Build{
+		ID:   "source-dir",
+		Name: "test-source-dir",
+		Sources: []builder.Source{
+			{Name: "root", Target: "testdata"},
+			{Name: "sub.src", Target: ".", Dir: "testdata"},
+		},
+		Volumes: []builder.Volume{
+			{Name: "sub.cache", Target: "cache", Dir: "testdata"},
+		},
+		Steps: []builder.Step{
+			{
+				Name: "sd1",
+				Imports: []builder.Mount{
+					{Source: "root", Mount: "/root"},
+					{Source: "sub.src", Mount: "/sub"},
+				},
+				Volumes: []builder.Mount{{Source: "sub.cache", Mount: "/cache"}},
+			},
+		},
+	}, func(ctx context.Context, exec builder.StepExec) error {
+		volume = exec.SourceDirs["sub.cache"]
+		return nil
+	})
+	require.NoError(t, r.Run(context.Background()))
+
+	// A source declared in a required build is read from its own directory.
+	require.Equal(t, r.getSrcDigest("root"), r.getSrcDigest("sub.src"))
+	require.Equal(t, wd+"/testdata/cache", volume)
+}
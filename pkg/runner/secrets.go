@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coldog/bld/pkg/log"
+)
+
+// Secrets returns the values of the secrets used by a step, every value is
+// registered to be redacted from log output.
+func (r *Runner) secrets(names []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, name := range names {
+		secret, ok := r.Build.Secret(name)
+		if !ok {
+			return nil, fmt.Errorf("secret not found: %s", name)
+		}
+		var val string
+		if secret.Env != "" {
+			v, ok := os.LookupEnv(secret.Env)
+			if !ok {
+				return nil, fmt.Errorf("secret %s: environment variable %s is not set", name, secret.Env)
+			}
+			val = v
+		} else {
+			file, err := r.secretFile(secret.Dir, secret.File)
+			if err != nil {
+				return nil, fmt.Errorf("secret %s: %v", name, err)
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("secret %s: %v", name, err)
+			}
+			val = string(data)
+		}
+		log.AddSecret(val)
+		values[name] = val
+	}
+	return values, nil
+}
+
+// secretFile resolves the path of a secret file, absolute paths and paths in
+// the home directory are used as is, others are relative to the build file.
+func (r *Runner) secretFile(dir, file string) (string, error) {
+	if file == "~" || strings.HasPrefix(file, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return path.Join(home, file[1:]), nil
+	}
+	if path.IsAbs(file) {
+		return file, nil
+	}
+	return r.dir(dir, file), nil
+}
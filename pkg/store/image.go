@@ -15,11 +15,16 @@ type ImageStore interface {
 	Save(ctx context.Context, name, id string) error
 	// Restore will restore a given image from the docker repo.
 	Restore(ctx context.Context, name, id string) error
+	// ImageID returns the ID of a given image in the docker daemon.
+	ImageID(ctx context.Context, name, id string) (string, error)
+	// Export writes a saved image as an OCI image layout tar archive.
+	Export(ctx context.Context, name, id string, w io.Writer) error
 }
 
 // NewImageStore instantiates a new store given a store implementation. This
-// will use docker load and docker save to store the images as tar archives in
-// the provided store.
+// will use docker load and docker save to store the images in the provided
+// store, saved archives are split into blobs so that layers shared between
+// images are only stored once.
 func NewImageStore(store Store) (ImageStore, error) {
 	client, err := client.NewEnvClient()
 	if err != nil {
@@ -44,19 +49,57 @@ func (s *imageStore) Save(ctx context.Context, name, id string) error {
 	if err != nil {
 		return err
 	}
-	return s.store.SaveStream(id, r)
+	defer r.Close()
+	_, err = splitImage(s.store, id, r)
+	return err
 }
 
 func (s *imageStore) Restore(ctx context.Context, name, id string) error {
-	log.ContextGetLogger(ctx).V(3).Printf("image store: restoring image id=%s", id)
+	logger := log.ContextGetLogger(ctx)
+	logger.V(3).Printf("image store: restoring image id=%s", id)
 
-	r, err := s.store.LoadStream(id)
+	manifest, err := loadManifest(s.store, id)
 	if err != nil {
-		return err
+		// Images saved before layers were split are stored as a single
+		// archive.
+		r, err := s.store.LoadStream(id)
+		if err != nil {
+			return err
+		}
+		return s.load(ctx, r)
+	}
+
+	if manifest.ImageID != "" {
+		if _, _, err := s.client.ImageInspectWithRaw(ctx, manifest.ImageID); err == nil {
+			logger.V(3).Printf("image store: image exists image=%s", manifest.ImageID)
+			return s.client.ImageTag(ctx, manifest.ImageID, name+":"+id)
+		}
 	}
 
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(joinImage(s.store, manifest, pw)) }()
+	return s.load(ctx, pr)
+}
+
+func (s *imageStore) load(ctx context.Context, r io.ReadCloser) error {
+	defer r.Close()
 	res, err := s.client.ImageLoad(ctx, r, true)
+	if err != nil {
+		return err
+	}
 	io.Copy(ioutil.Discard, res.Body)
-	res.Body.Close()
-	return err
+	return res.Body.Close()
+}
+
+func (s *imageStore) ImageID(ctx context.Context, name, id string) (string, error) {
+	inspect, _, err := s.client.ImageInspectWithRaw(ctx, name+":"+id)
+	if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+func (s *imageStore) Export(ctx context.Context, name, id string, w io.Writer) error {
+	log.ContextGetLogger(ctx).V(3).Printf("image store: exporting image id=%s", id)
+	return exportOCI(s.store, id, name+":"+id, w)
 }
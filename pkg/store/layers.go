@@ -0,0 +1,215 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// imageManifest describes a `docker save` archive where the content of every
+// file is stored as a separate blob addressed by its digest. Layers shared
+// between images are stored once.
+type imageManifest struct {
+	ImageID string        `json:"imageID"`
+	Entries []*imageEntry `json:"entries"`
+}
+
+type imageEntry struct {
+	Name     string    `json:"name"`
+	Typeflag byte      `json:"type"`
+	Mode     int64     `json:"mode"`
+	ModTime  time.Time `json:"modTime"`
+	Linkname string    `json:"linkname,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Digest   string    `json:"digest,omitempty"`
+}
+
+// saveManifest is the manifest.json file written by `docker save`.
+type saveManifest []struct {
+	Config string   `json:"Config"`
+	Layers []string `json:"Layers"`
+}
+
+// entry returns the entry for a file in the archive, symlinks are followed.
+func (m *imageManifest) entry(name string) (*imageEntry, bool) {
+	for _, e := range m.Entries {
+		if e.Name != name {
+			continue
+		}
+		if e.Typeflag == tar.TypeSymlink {
+			return m.entry(path.Join(path.Dir(name), e.Linkname))
+		}
+		return e, e.Digest != ""
+	}
+	return nil, false
+}
+
+// saveManifest returns the parsed manifest.json of the archive.
+func (m *imageManifest) saveManifest(store Store) (saveManifest, error) {
+	e, ok := m.entry("manifest.json")
+	if !ok {
+		return nil, fmt.Errorf("image archive has no manifest.json")
+	}
+	r, err := store.LoadStream(blobKey(e.Digest))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var sm saveManifest
+	if err := json.NewDecoder(r).Decode(&sm); err != nil {
+		return nil, err
+	}
+	if len(sm) == 0 {
+		return nil, fmt.Errorf("image archive has an empty manifest.json")
+	}
+	return sm, nil
+}
+
+func manifestKey(id string) string { return "images/" + id }
+
+// blobKey returns the key of a blob, it matches the path of blobs in an OCI
+// image layout.
+func blobKey(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func digestBytes(data []byte) string {
+	h := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func exists(store Store, id string) bool {
+	r, err := store.LoadStream(id)
+	if err != nil {
+		return false
+	}
+	r.Close()
+	return true
+}
+
+// splitImage stores every file of a `docker save` archive as a blob and saves
+// the manifest to reassemble it under id.
+func splitImage(store Store, id string, r io.Reader) (*imageManifest, error) {
+	manifest := &imageManifest{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		entry := &imageEntry{
+			Name:     hdr.Name,
+			Typeflag: hdr.Typeflag,
+			Mode:     hdr.Mode,
+			ModTime:  hdr.ModTime,
+			Linkname: hdr.Linkname,
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		entry.Size = hdr.Size
+		entry.Digest, err = saveBlob(store, ioutil.NopCloser(tr))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The image ID is the digest of the image config.
+	if sm, err := manifest.saveManifest(store); err == nil {
+		if config, ok := manifest.entry(sm[0].Config); ok {
+			manifest.ImageID = config.Digest
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SaveStream(manifestKey(id), ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// saveBlob writes the stream to a scratch file to compute its digest, the
+// blob is only saved to the store if it does not already exist.
+func saveBlob(store Store, r io.ReadCloser) (string, error) {
+	f, err := ioutil.TempFile("", "bld-blob-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if exists(store, blobKey(digest)) {
+		return digest, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return digest, store.SaveStream(blobKey(digest), f)
+}
+
+// loadManifest returns the manifest saved under id.
+func loadManifest(store Store, id string) (*imageManifest, error) {
+	r, err := store.LoadStream(manifestKey(id))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	manifest := &imageManifest{}
+	if err := json.NewDecoder(r).Decode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// joinImage reassembles the `docker save` archive described by manifest.
+func joinImage(store Store, manifest *imageManifest, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, entry := range manifest.Entries {
+		hdr := &tar.Header{
+			Name:     entry.Name,
+			Typeflag: entry.Typeflag,
+			Mode:     entry.Mode,
+			ModTime:  entry.ModTime,
+			Linkname: entry.Linkname,
+			Size:     entry.Size,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if entry.Digest == "" {
+			continue
+		}
+		r, err := store.LoadStream(blobKey(entry.Digest))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
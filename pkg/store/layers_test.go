@@ -0,0 +1,79 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func saveArchive(t *testing.T, files map[string]string, order []string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range order {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+		}))
+		_, err := tw.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestImageLayers(t *testing.T) {
+	sDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	s := &local{dir: sDir}
+
+	order := []string{"manifest.json", "abc.json", "base/layer.tar", "top/layer.tar"}
+	image1 := saveArchive(t, map[string]string{
+		"manifest.json":  `[{"Config": "abc.json"}]`,
+		"abc.json":       "config1",
+		"base/layer.tar": "base layer",
+		"top/layer.tar":  "top layer 1",
+	}, order)
+	image2 := saveArchive(t, map[string]string{
+		"manifest.json":  `[{"Config": "abc.json"}]`,
+		"abc.json":       "config2",
+		"base/layer.tar": "base layer",
+		"top/layer.tar":  "top layer 2",
+	}, order)
+
+	m1, err := splitImage(s, "image1", bytes.NewReader(image1))
+	require.NoError(t, err)
+	require.Equal(t, m1.Entries[1].Digest, m1.ImageID)
+	m2, err := splitImage(s, "image2", bytes.NewReader(image2))
+	require.NoError(t, err)
+
+	// The base layer and manifest are shared.
+	require.Equal(t, m1.Entries[2].Digest, m2.Entries[2].Digest)
+	blobs, err := ioutil.ReadDir(sDir + "/store/content/blobs/sha256")
+	require.NoError(t, err)
+	require.Len(t, blobs, 6)
+
+	m, err := loadManifest(s, "image2")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, joinImage(s, m, buf))
+
+	joined := buf.Bytes()
+
+	tr := tar.NewReader(bytes.NewReader(joined))
+	for _, name := range order {
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, name, hdr.Name)
+	}
+	_, err = tr.Next()
+	require.Error(t, err)
+
+	restored, err := splitImage(s, "image3", bytes.NewReader(joined))
+	require.NoError(t, err)
+	require.Equal(t, m2.Entries, restored.Entries)
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// OCI media types.
+const (
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar"
+
+	annotationRefName = "org.opencontainers.image.ref.name"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// exportOCI writes the image saved under id as an OCI image layout tar
+// archive, the image manifest is annotated with ref. Layers are written
+// uncompressed, their digests are the same as in the image config.
+func exportOCI(store Store, id, ref string, w io.Writer) error {
+	manifest, err := loadManifest(store, id)
+	if err != nil {
+		// Images saved before layers were split are stored as a single
+		// archive, split it to read the individual files.
+		r, lerr := store.LoadStream(id)
+		if lerr != nil {
+			return fmt.Errorf("image %s not found: %v", id, err)
+		}
+		manifest, err = splitImage(store, id, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	sm, err := manifest.saveManifest(store)
+	if err != nil {
+		return err
+	}
+
+	config, ok := manifest.entry(sm[0].Config)
+	if !ok {
+		return fmt.Errorf("image config %s not found", sm[0].Config)
+	}
+	m := ociManifest{
+		SchemaVersion: 2,
+		Config: ociDescriptor{
+			MediaType: mediaTypeConfig,
+			Digest:    config.Digest,
+			Size:      config.Size,
+		},
+		Layers: []ociDescriptor{},
+	}
+	blobs := []ociDescriptor{m.Config}
+	for _, name := range sm[0].Layers {
+		layer, ok := manifest.entry(name)
+		if !ok {
+			return fmt.Errorf("image layer %s not found", name)
+		}
+		desc := ociDescriptor{
+			MediaType: mediaTypeLayer,
+			Digest:    layer.Digest,
+			Size:      layer.Size,
+		}
+		m.Layers = append(m.Layers, desc)
+		blobs = append(blobs, desc)
+	}
+
+	manifestData, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	index := ociIndex{
+		SchemaVersion: 2,
+		Manifests: []ociDescriptor{{
+			MediaType:   mediaTypeManifest,
+			Digest:      digestBytes(manifestData),
+			Size:        int64(len(manifestData)),
+			Annotations: map[string]string{annotationRefName: ref},
+		}},
+	}
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := writeFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+	if err := writeFile("index.json", indexData); err != nil {
+		return err
+	}
+	if err := writeFile(blobKey(index.Manifests[0].Digest), manifestData); err != nil {
+		return err
+	}
+
+	written := map[string]bool{}
+	for _, blob := range blobs {
+		if written[blob.Digest] {
+			continue
+		}
+		written[blob.Digest] = true
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     blobKey(blob.Digest),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     blob.Size,
+			ModTime:  now,
+		}); err != nil {
+			return err
+		}
+		r, err := store.LoadStream(blobKey(blob.Digest))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
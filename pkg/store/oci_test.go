@@ -0,0 +1,55 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportOCI(t *testing.T) {
+	sDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	s := &local{dir: sDir}
+
+	image := saveArchive(t, map[string]string{
+		"manifest.json":  `[{"Config": "abc.json", "Layers": ["base/layer.tar", "top/layer.tar"]}]`,
+		"abc.json":       `{"architecture": "amd64"}`,
+		"base/layer.tar": "base layer",
+		"top/layer.tar":  "top layer",
+	}, []string{"manifest.json", "abc.json", "base/layer.tar", "top/layer.tar"})
+
+	// Images saved as a single archive are also exported.
+	require.NoError(t, s.SaveStream("image", ioutil.NopCloser(bytes.NewReader(image))))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, exportOCI(s, "image", "step:image", buf))
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = data
+	}
+	require.Contains(t, files, "oci-layout")
+
+	var index ociIndex
+	require.NoError(t, json.Unmarshal(files["index.json"], &index))
+	require.Len(t, index.Manifests, 1)
+	require.Equal(t, "step:image", index.Manifests[0].Annotations[annotationRefName])
+
+	var manifest ociManifest
+	require.NoError(t, json.Unmarshal(files[blobKey(index.Manifests[0].Digest)], &manifest))
+	require.Equal(t, digestBytes([]byte(`{"architecture": "amd64"}`)), manifest.Config.Digest)
+	require.Len(t, manifest.Layers, 2)
+	require.Equal(t, "base layer", string(files[blobKey(manifest.Layers[0].Digest)]))
+	require.Equal(t, "top layer", string(files[blobKey(manifest.Layers[1].Digest)]))
+}
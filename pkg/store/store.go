@@ -28,12 +28,30 @@ type local struct {
 	dir string
 }
 
+// tempFile returns a scratch path next to key, content is written here first
+// and renamed into place so that readers never observe a partial write.
+func tempFile(key string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(key), 0700); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(key), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
 func (s *local) Save(id, dir string) error {
 	key := s.dir + "/store/content/" + id
-	if err := os.MkdirAll(filepath.Dir(key), 0700); err != nil {
+	tmp, err := tempFile(key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := fileutils.Tar(dir, tmp); err != nil {
 		return err
 	}
-	return fileutils.Tar(dir, key)
+	return os.Rename(tmp, key)
 }
 
 func (s *local) Load(id, dir string) error {
@@ -43,14 +61,21 @@ func (s *local) Load(id, dir string) error {
 
 func (s *local) SaveStream(id string, stream io.ReadCloser) error {
 	key := s.dir + "/store/content/" + id
-	if err := os.MkdirAll(filepath.Dir(key), 0700); err != nil {
+	tmp, err := tempFile(key)
+	if err != nil {
+		stream.Close()
 		return err
 	}
-	f, err := os.OpenFile(key, os.O_CREATE|os.O_RDWR, 0700)
+	defer os.Remove(tmp)
+	f, err := os.OpenFile(tmp, os.O_TRUNC|os.O_RDWR, 0700)
 	if err != nil {
+		stream.Close()
+		return err
+	}
+	if err := fileutils.CopyStream(stream, f); err != nil {
 		return err
 	}
-	return fileutils.CopyStream(stream, f)
+	return os.Rename(tmp, key)
 }
 
 func (s *local) LoadStream(id string) (io.ReadCloser, error) {
@@ -60,10 +85,15 @@ func (s *local) LoadStream(id string) (io.ReadCloser, error) {
 
 func (s *local) PutKey(id, val string) error {
 	key := s.dir + "/store/keys/" + id
-	if err := os.MkdirAll(filepath.Dir(key), 0700); err != nil {
+	tmp, err := tempFile(key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := ioutil.WriteFile(tmp, []byte(val), 0700); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(key, []byte(val), 0700)
+	return os.Rename(tmp, key)
 }
 
 func (s *local) GetKey(id string) (string, error) {
@@ -46,3 +46,24 @@ func TestStoreLocal_Keys(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, val, "hi")
 }
+
+func TestStoreLocal_NoPartialWrites(t *testing.T) {
+	sDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+
+	s := &local{dir: sDir}
+
+	err = s.PutKey("test", "hi")
+	require.Nil(t, err)
+	err = s.PutKey("test", "hello")
+	require.Nil(t, err)
+
+	files, err := ioutil.ReadDir(sDir + "/store/keys")
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "test", files[0].Name())
+
+	val, err := s.GetKey("test")
+	require.Nil(t, err)
+	require.Equal(t, "hello", val)
+}
@@ -0,0 +1,117 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	gotemplate "text/template"
+)
+
+// Version is a parsed semantic version.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Metadata   string
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Metadata != "" {
+		s += "+" + v.Metadata
+	}
+	return s
+}
+
+var semverRe = regexp.MustCompile(
+	`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+// ParseVersion parses a semantic version, a leading "v" is allowed.
+func ParseVersion(s string) (Version, error) {
+	m := semverRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid semantic version %q", s)
+	}
+	v := Version{Prerelease: m[4], Metadata: m[5]}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	return v, nil
+}
+
+var slugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug lowercases s and replaces every run of characters that are not letters
+// or digits with "-", for example "feature/Add_Thing" is "feature-add-thing".
+func slug(s string) string {
+	return strings.Trim(slugRe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func truncate(n int, s string) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// empty returns true for the zero value of the types available to templates.
+func empty(val interface{}) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int:
+		return v == 0
+	}
+	return false
+}
+
+// funcs returns the functions available to templates. Values are the last
+// argument so functions can be chained, `{{ .Git.Branch | slug | upper }}`.
+func funcs(data Data) gotemplate.FuncMap {
+	return gotemplate.FuncMap{
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       func(sep string, s []string) string { return strings.Join(s, sep) },
+		"slug":       slug,
+		"truncate":   truncate,
+		"default": func(def, val interface{}) interface{} {
+			if empty(val) {
+				return def
+			}
+			return val
+		},
+		"readFile": func(name string) (string, error) {
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(data.Dir, name)
+			}
+			b, err := ioutil.ReadFile(name)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(b)), nil
+		},
+		"semver": ParseVersion,
+		"now": func(layout string) (string, error) {
+			return data.Now.Format(layout), data.Inputs.build("now")
+		},
+	}
+}
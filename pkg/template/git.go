@@ -0,0 +1,62 @@
+package template
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Git is the git metadata available to templates as {{ .Git.<field> }}.
+type Git struct {
+	Sha      string
+	ShaShort string
+	Branch   string
+	// Tag is the tag pointing at the commit, if any.
+	Tag string
+	// Dirty is true if the working tree has uncommitted changes.
+	Dirty bool
+	// Time is the commit timestamp.
+	Time time.Time
+}
+
+// GitProvider returns the git metadata of a working tree.
+type GitProvider func() Git
+
+// ExecGit returns a provider that runs git in dir the first time it is called,
+// later calls return the same values. Values that can not be read, for example
+// outside of a repository, are left empty.
+func ExecGit(dir string) GitProvider {
+	var (
+		once sync.Once
+		g    Git
+	)
+	return func() Git {
+		once.Do(func() { g = execGit(dir) })
+		return g
+	}
+}
+
+func execGit(dir string) Git {
+	sh := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	g := Git{
+		Sha:      sh("rev-parse", "HEAD"),
+		ShaShort: sh("rev-parse", "--short", "HEAD"),
+		Branch:   sh("rev-parse", "--abbrev-ref", "HEAD"),
+		Tag:      sh("describe", "--tags", "--exact-match", "HEAD"),
+		Dirty:    sh("status", "--porcelain") != "",
+	}
+	if t, err := time.Parse(time.RFC3339, sh("log", "-1", "--format=%cI")); err == nil {
+		g.Time = t
+	}
+	return g
+}
@@ -0,0 +1,134 @@
+package template
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Inputs limit the values from outside the build file that templates can
+// reference. Git keys are matched ignoring case, build keys are "id" and
+// "now".
+type Inputs struct {
+	Env   []string
+	Git   []string
+	Build []string
+}
+
+func (in *Inputs) git(key string) error {
+	if in == nil {
+		return nil
+	}
+	for _, k := range in.Git {
+		if strings.EqualFold(k, key) {
+			return nil
+		}
+	}
+	return fmt.Errorf("git value %s is not declared in inputs.git", key)
+}
+
+func (in *Inputs) build(key string) error {
+	if in == nil {
+		return nil
+	}
+	for _, k := range in.Build {
+		if k == key {
+			return nil
+		}
+	}
+	return fmt.Errorf("build value %s is not declared in inputs.build", key)
+}
+
+// scope is the value templates are executed against. The values of data are
+// only reachable through methods so the inputs are checked on every access,
+// however the template gets to them.
+type scope struct{ data Data }
+
+// Environ returns the declared environment variables, variables that are
+// declared but not set are empty.
+func (s scope) Environ() map[string]string {
+	in := s.data.Inputs
+	if in == nil {
+		return s.data.Environ
+	}
+	env := map[string]string{}
+	for _, name := range in.Env {
+		env[name] = s.data.Environ[name]
+	}
+	return env
+}
+
+func (s scope) Vars() map[string]string   { return s.data.Vars }
+func (s scope) Matrix() map[string]string { return s.data.Matrix }
+func (s scope) Step() StepInfo            { return s.data.Step }
+func (s scope) Git() gitScope             { return gitScope(s) }
+func (s scope) Build() buildScope         { return buildScope(s) }
+
+// Format prints nothing so the values in data are never printed as a whole.
+func (s scope) Format(f fmt.State, verb rune) { io.WriteString(f, "{}") }
+
+type gitScope scope
+
+func (s gitScope) git() Git {
+	if s.data.Git == nil {
+		return Git{}
+	}
+	return s.data.Git()
+}
+
+func (s gitScope) Sha() (string, error) {
+	if err := s.data.Inputs.git("Sha"); err != nil {
+		return "", err
+	}
+	return s.git().Sha, nil
+}
+
+func (s gitScope) ShaShort() (string, error) {
+	if err := s.data.Inputs.git("ShaShort"); err != nil {
+		return "", err
+	}
+	return s.git().ShaShort, nil
+}
+
+func (s gitScope) Branch() (string, error) {
+	if err := s.data.Inputs.git("Branch"); err != nil {
+		return "", err
+	}
+	return s.git().Branch, nil
+}
+
+func (s gitScope) Tag() (string, error) {
+	if err := s.data.Inputs.git("Tag"); err != nil {
+		return "", err
+	}
+	return s.git().Tag, nil
+}
+
+func (s gitScope) Dirty() (bool, error) {
+	if err := s.data.Inputs.git("Dirty"); err != nil {
+		return false, err
+	}
+	return s.git().Dirty, nil
+}
+
+func (s gitScope) Time() (time.Time, error) {
+	if err := s.data.Inputs.git("Time"); err != nil {
+		return time.Time{}, err
+	}
+	return s.git().Time, nil
+}
+
+// Format prints nothing, see scope.Format.
+func (s gitScope) Format(f fmt.State, verb rune) { io.WriteString(f, "{}") }
+
+type buildScope scope
+
+func (s buildScope) ID() (string, error) {
+	return s.data.Build.ID, s.data.Inputs.build("id")
+}
+
+func (s buildScope) Name() string { return s.data.Build.Name }
+
+// Format prints nothing, see scope.Format.
+func (s buildScope) Format(f fmt.State, verb rune) { io.WriteString(f, "{}") }
@@ -2,30 +2,15 @@ package template
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
 	"os"
-	"os/exec"
+	"reflect"
+	"regexp"
 	"strings"
 	gotemplate "text/template"
+	"time"
 )
 
-func sh(arg string, args ...string) *string {
-	out, err := exec.Command(arg, args...).CombinedOutput()
-	if err != nil {
-		return nil
-	}
-	s := strings.TrimSpace(string(out))
-	return &s
-}
-
-func gitMap() map[string]*string {
-	return map[string]*string{
-		"ShaShort": sh("git", "rev-parse", "--short", "HEAD"),
-		"Sha":      sh("git", "rev-parse", "HEAD"),
-		"Branch":   sh("git", "rev-parse", "--abbrev-ref", "HEAD"),
-	}
-}
-
 func environMap() map[string]string {
 	m := map[string]string{}
 	for _, val := range os.Environ() {
@@ -37,28 +22,147 @@ func environMap() map[string]string {
 	return m
 }
 
-// Struct template.
-func Struct(i interface{}) error {
-	data, err := json.Marshal(i)
-	if err != nil {
-		return err
+var varsRe = regexp.MustCompile(`\.Vars\.([a-zA-Z0-9_]+)`)
+
+// Data is the input available to templates.
+type Data struct {
+	// Git is only called when a template references a git value, it may be
+	// nil.
+	Git     GitProvider
+	Environ map[string]string
+	Vars    map[string]string
+	Matrix  map[string]string
+	Build   BuildInfo
+	Step    StepInfo
+
+	// Dir is the directory readFile paths are relative to.
+	Dir string
+	// Now is the time formatted by the now function, it is fixed when the
+	// data is created so every template sees the same time.
+	Now time.Time
+
+	// Inputs limit the environment variables, git values and build values
+	// templates can reference, nil allows every value.
+	Inputs *Inputs
+}
+
+// BuildInfo describes the build being rendered.
+type BuildInfo struct {
+	ID   string
+	Name string
+}
+
+// StepInfo describes the step being rendered.
+type StepInfo struct {
+	Name string
+}
+
+// NewData returns template data with the git and environment values set, git
+// may be nil.
+func NewData(git GitProvider, vars map[string]string) Data {
+	return Data{
+		Git:     git,
+		Environ: environMap(),
+		Vars:    vars,
+		Now:     time.Now(),
 	}
-	tpl, err := gotemplate.New("").Parse(string(data))
-	if err != nil {
-		return err
+}
+
+// Render executes every exported string in the value pointed to by i as a
+// template, including strings in nested structs, slices and maps. Errors name
+// the field they came from using its JSON name, for example "steps[0].image".
+func Render(i interface{}, data Data) error {
+	return walk(reflect.ValueOf(i), "", func(path string, v reflect.Value) error {
+		if !v.CanSet() || !strings.Contains(v.String(), "{{") {
+			return nil
+		}
+		out, err := execute(v.String(), data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		v.SetString(out)
+		return nil
+	})
+}
+
+// GitKeys are the git values available to templates as {{ .Git.<key> }}.
+var GitKeys = []string{"Sha", "ShaShort", "Branch", "Tag", "Dirty", "Time"}
+
+// walk calls fn for every exported string in v, path is the JSON path of the
+// string.
+func walk(v reflect.Value, path string, fn func(path string, v reflect.Value) error) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return walk(v.Elem(), path, fn)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if field.Anonymous {
+				name = ""
+			}
+			if err := walk(v.Field(i), joinPath(path, name), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walk(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			// Map values are not addressable, walk a copy and store it.
+			val := reflect.New(v.Type().Elem()).Elem()
+			val.Set(v.MapIndex(key))
+			if err := walk(val, joinPath(path, fmt.Sprint(key.Interface())), fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, val)
+		}
+	case reflect.String:
+		return fn(path, v)
 	}
-	buf := bytes.NewBuffer(nil)
-	input := struct {
-		Git     map[string]*string
-		Environ map[string]string
-	}{
-		Git:     gitMap(),
-		Environ: environMap(),
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" || name == "" {
+		return path + name
 	}
-	err = tpl.Execute(buf, input)
+	return path + "." + name
+}
+
+// execute renders a single template string. Variables referenced as
+// {{ .Vars.name }} must be declared, values outside of data.Inputs and missing
+// map keys fail the template.
+func execute(s string, data Data) (string, error) {
+	for _, m := range varsRe.FindAllStringSubmatch(s, -1) {
+		if _, ok := data.Vars[m[1]]; !ok {
+			return "", fmt.Errorf("variable %q is not declared", m[1])
+		}
+	}
+	tpl, err := gotemplate.New("").Option("missingkey=error").Funcs(funcs(data)).Parse(s)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	return json.Unmarshal(buf.Bytes(), i)
+	buf := bytes.NewBuffer(nil)
+	if err := tpl.Execute(buf, scope{data}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
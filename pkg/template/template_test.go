@@ -2,7 +2,10 @@ package template
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -13,7 +16,168 @@ func TestTemplate(t *testing.T) {
 	}{
 		Hello: "Commit: {{ .Git.ShaShort }}, Branch: {{ .Git.Branch }}",
 	}
-	err := Struct(s)
+	err := Render(s, NewData(nil, nil))
 	require.NoError(t, err)
 	fmt.Printf("%+v\n", s)
 }
+
+func TestRender(t *testing.T) {
+	type step struct {
+		Name     string            `json:"name"`
+		Commands []string          `json:"commands"`
+		Env      map[string]string `json:"env"`
+		Build    *struct {
+			Tag string `json:"tag"`
+		} `json:"build"`
+	}
+	s := &struct {
+		Steps []step `json:"steps"`
+	}{
+		Steps: []step{{
+			Name:     "{{ .Vars.name }}",
+			Commands: []string{`echo "{{ .Vars.quoted }}"`},
+			Env:      map[string]string{"KEY": "{{ .Vars.name }}"},
+			Build: &struct {
+				Tag string `json:"tag"`
+			}{Tag: "app:{{ .Matrix.version }}"},
+		}},
+	}
+	data := NewData(nil, map[string]string{"name": "test", "quoted": `say "hi"`})
+	data.Matrix = map[string]string{"version": "10"}
+	require.NoError(t, Render(s, data))
+	require.Equal(t, "test", s.Steps[0].Name)
+	require.Equal(t, `echo "say "hi""`, s.Steps[0].Commands[0])
+	require.Equal(t, "test", s.Steps[0].Env["KEY"])
+	require.Equal(t, "app:10", s.Steps[0].Build.Tag)
+
+	s.Steps[0].Commands = []string{"{{ .Vars.missing }}"}
+	err := Render(s, data)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "steps[0].commands[0]")
+
+	s.Steps[0].Commands = []string{"{{ .Vars.name"}
+	err = Render(s, data)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "steps[0].commands[0]")
+}
+
+func TestTemplateVars(t *testing.T) {
+	s := &struct {
+		Image string
+	}{
+		Image: "node:{{ .Vars.version }}",
+	}
+	err := Render(s, NewData(nil, map[string]string{"version": "10"}))
+	require.NoError(t, err)
+	require.Equal(t, "node:10", s.Image)
+
+	s.Image = "node:{{ .Vars.missing }}"
+	err = Render(s, NewData(nil, map[string]string{"version": "10"}))
+	require.Error(t, err)
+}
+
+func TestFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(dir+"/version", []byte("v1.4.2-rc.1\n"), 0644))
+
+	git := func() Git {
+		return Git{
+			Sha:      "4b8de3c1a2",
+			ShaShort: "4b8de3c",
+			Branch:   "feature/Add_Thing",
+			Dirty:    true,
+			Time:     time.Date(2019, 3, 4, 12, 0, 0, 0, time.UTC),
+		}
+	}
+	data := NewData(git, map[string]string{"empty": ""})
+	data.Dir = dir
+	data.Now = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	data.Build = BuildInfo{ID: "1234", Name: "app"}
+	data.Step = StepInfo{Name: "test"}
+
+	for tpl, expected := range map[string]string{
+		`{{ .Git.Branch | slug }}`:                                    "feature-add-thing",
+		`{{ .Git.ShaShort | upper }}`:                                 "4B8DE3C",
+		`{{ .Git.Dirty }}`:                                            "true",
+		`{{ .Git.Time.Format "20060102" }}`:                           "20190304",
+		`{{ default "latest" .Git.Tag }}`:                             "latest",
+		`{{ default "none" .Vars.empty }}`:                            "none",
+		`{{ readFile "version" }}`:                                    "v1.4.2-rc.1",
+		`{{ (semver (readFile "version")).Major }}`:                   "1",
+		`{{ with semver (readFile "version") }}{{ .Minor }}{{ end }}`: "4",
+		`{{ now "2006-01-02" }}`:                                      "2020-01-02",
+		`{{ .Build.Name }}-{{ .Build.ID }}-{{ .Step.Name }}`:          "app-1234-test",
+		`{{ "a/b/c" | replace "/" "." | trimPrefix "a." }}`:           "b.c",
+		`{{ .Git.Sha | truncate 4 }}`:                                 "4b8d",
+	} {
+		out, err := execute(tpl, data)
+		require.NoError(t, err, tpl)
+		require.Equal(t, expected, out, tpl)
+	}
+
+	_, err = execute(`{{ semver "latest" }}`, data)
+	require.Error(t, err)
+	_, err = execute(`{{ readFile "missing" }}`, data)
+	require.Error(t, err)
+}
+
+func TestInputs(t *testing.T) {
+	os.Setenv("BLD_TEST_DECLARED", "declared")
+	defer os.Unsetenv("BLD_TEST_DECLARED")
+
+	git := func() Git { return Git{Sha: "4b8de3c1a2", Branch: "master"} }
+	data := NewData(git, map[string]string{"name": "app"})
+	data.Build = BuildInfo{ID: "1234", Name: "app"}
+	data.Inputs = &Inputs{Env: []string{"BLD_TEST_DECLARED", "BLD_TEST_UNSET"}, Git: []string{"sha"}}
+
+	for tpl, expected := range map[string]string{
+		`{{ .Environ.BLD_TEST_DECLARED }}`:                     "declared",
+		`{{ .Environ.BLD_TEST_UNSET }}`:                        "",
+		`{{ with .Environ }}{{ .BLD_TEST_DECLARED }}{{ end }}`: "declared",
+		`{{ range $k, $v := .Environ }}{{ $k }} {{ end }}`:     "BLD_TEST_DECLARED BLD_TEST_UNSET ",
+		`{{ .Git.Sha }}`:    "4b8de3c1a2",
+		`{{ .Build.Name }}`: "app",
+		`{{ .Git }} {{ .Build }} {{ printf "%#v %d" . . }}`: "{} {} {} {}",
+	} {
+		out, err := execute(tpl, data)
+		require.NoError(t, err, tpl)
+		require.Equal(t, expected, out, tpl)
+	}
+
+	for _, tpl := range []string{
+		`{{ .Environ.HOME }}`,
+		`{{ $e := .Environ }}{{ $e.HOME }}`,
+		`{{ with .Environ }}{{ .HOME }}{{ end }}`,
+		`{{ .Git.Branch }}`,
+		`{{ with .Git }}{{ .Tag }}{{ end }}`,
+		`{{ .Build.ID }}`,
+		`{{ now "2006" }}`,
+		`{{ .Undeclared }}`,
+	} {
+		_, err := execute(tpl, data)
+		require.Error(t, err, tpl)
+	}
+}
+
+func TestGitLazy(t *testing.T) {
+	calls := 0
+	git := func() Git {
+		calls++
+		return Git{Sha: "4b8de3c1a2"}
+	}
+	data := NewData(git, map[string]string{"now": "today"})
+	data.Inputs = &Inputs{Git: []string{"sha"}}
+
+	// A variable named now is not the now function.
+	out, err := execute(`{{ .Vars.now }}`, data)
+	require.NoError(t, err)
+	require.Equal(t, "today", out)
+	require.Equal(t, 0, calls)
+
+	out, err = execute(`{{ .Git.Sha }}`, data)
+	require.NoError(t, err)
+	require.Equal(t, "4b8de3c1a2", out)
+	require.Equal(t, 1, calls)
+}